@@ -0,0 +1,166 @@
+package syncer
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/enorith/syncer/ds"
+)
+
+// AggregateConfig rolls the mapped rows of a task up to one row per group, fields are target columns
+type AggregateConfig struct {
+	GroupBy    []string       `json:"group_by"`
+	Aggregates []ds.Aggregate `json:"aggregates"`
+}
+
+// aggregatePlan how a task aggregates, pushed down into the source query, or in memory per target
+type aggregatePlan struct {
+	conf     AggregateConfig
+	pushdown bool
+	// groupBy and aggregates of the source query, columns renames its group columns to target columns
+	groupBy    []string
+	aggregates []ds.Aggregate
+	columns    map[string]string
+}
+
+// newAggregatePlan pushes the aggregation down when the source aggregates, the task has one target
+// and every field is mapped from a source field as is
+func newAggregatePlan(task SyncerTask, source ds.Datasource) (*aggregatePlan, error) {
+	if task.Aggregate == nil {
+		return nil, nil
+	}
+
+	conf := *task.Aggregate
+	if len(conf.GroupBy) == 0 && len(conf.Aggregates) == 0 {
+		return nil, errors.New("[syncer] aggregate requires group_by or aggregates")
+	}
+	if e := ds.CheckAggregates(conf.Aggregates); e != nil {
+		return nil, e
+	}
+
+	plan := &aggregatePlan{conf: conf}
+
+	aggregator, ok := source.(ds.Aggregator)
+	targets := task.taskTargets()
+	if !ok || !aggregator.CanAggregate() || len(targets) != 1 {
+		return plan, nil
+	}
+
+	mapping := ParseMapping(targets[0].Mapping)
+	plan.columns = make(map[string]string, len(conf.GroupBy))
+	for _, column := range conf.GroupBy {
+		field, ok := plainSource(mapping, column)
+		if !ok {
+			return plan, nil
+		}
+		plan.groupBy = append(plan.groupBy, field)
+		plan.columns[field] = column
+	}
+
+	for _, a := range conf.Aggregates {
+		pushed := ds.Aggregate{Func: a.Func, As: a.Name()}
+		if a.Field != "" {
+			field, ok := plainSource(mapping, a.Field)
+			if !ok {
+				return plan, nil
+			}
+			pushed.Field = field
+		}
+		plan.aggregates = append(plan.aggregates, pushed)
+	}
+	plan.pushdown = true
+
+	return plan, nil
+}
+
+// plainSource the source field a column is mapped from without resolvers
+func plainSource(mapping Mapping, column string) (string, bool) {
+	var found *MappingStep
+	for i := range mapping {
+		if mapping[i].Column == column {
+			found = &mapping[i]
+		}
+	}
+
+	if found == nil || found.Chained || len(found.Resolvers) > 0 {
+		return "", false
+	}
+
+	return found.Source, true
+}
+
+// listOption adds the source aggregation to opt, ordering by the groups unless the task orders
+func (p *aggregatePlan) listOption(opt ds.ListOption) ds.ListOption {
+	if p == nil || !p.pushdown {
+		return opt
+	}
+
+	opt.GroupBy = p.groupBy
+	opt.Aggregates = p.aggregates
+	if len(opt.Orders) == 0 {
+		for _, field := range p.groupBy {
+			opt.Orders = append(opt.Orders, ds.ListOrder{Field: field, Order: "asc"})
+		}
+	}
+
+	return opt
+}
+
+// rename the group columns of aggregated source rows to their target columns
+func (p *aggregatePlan) rename(rows []map[string]any) []map[string]any {
+	items := make([]map[string]any, len(rows))
+	for i, row := range rows {
+		item := make(map[string]any, len(row))
+		for field, value := range row {
+			if column, ok := p.columns[field]; ok {
+				field = column
+			}
+			item[field] = value
+		}
+		items[i] = item
+	}
+
+	return items
+}
+
+// targetColumns the columns of the aggregated rows, aggregates take the type of their field
+// except counts, and sums which are floats unless the field is an int
+func (p *aggregatePlan) targetColumns(columns []ds.Column) []ds.Column {
+	byName := columnsByName(columns)
+	column := func(name string) ds.Column {
+		return byName[strings.ToLower(name)]
+	}
+
+	var out []ds.Column
+	for _, name := range p.conf.GroupBy {
+		c := column(name)
+		if c.Name == "" {
+			c.Nullable = true
+		}
+		c.Name = name
+		out = append(out, c)
+	}
+
+	for _, a := range p.conf.Aggregates {
+		c := ds.Column{Name: a.Name(), Nullable: true}
+		switch a.Func {
+		case ds.AggCount, ds.AggCountDistinct:
+			c.Type, c.Nullable = ds.TypeInt, false
+		case ds.AggSum:
+			c.Type = ds.TypeFloat
+			switch field := column(a.Field); field.Type {
+			case ds.TypeInt:
+				c.Type = ds.TypeInt
+			case ds.TypeDecimal:
+				// sums outgrow the precision of the field, the scale is kept
+				c.Type, c.Scale = ds.TypeDecimal, field.Scale
+			}
+		default:
+			field := column(a.Field)
+			c.Type, c.Length, c.Precision, c.Scale = field.Type, field.Length, field.Precision, field.Scale
+		}
+		out = append(out, c)
+	}
+
+	return out
+}
@@ -0,0 +1,99 @@
+package syncer_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/enorith/syncer"
+	"github.com/enorith/syncer/ds"
+)
+
+func TestAggregate(t *testing.T) {
+	sy, dir := fileTasks(t, map[string]string{
+		"orders.csv": "shop,amount,customer\na,10,x\nb,5,x\na,7,y\na,3,x\nb,1.5,z\n",
+	}, `[{
+		"id": "totals",
+		"source": "csv://%[1]s/orders.csv",
+		"mapping": {"shop": "shop_id", "amount": "amount", "customer": "customer"},
+		"target": "jsonl://%[1]s/totals.jsonl",
+		"aggregate": {
+			"group_by": ["shop_id"],
+			"aggregates": [
+				{"func": "sum", "field": "amount", "as": "total"},
+				{"func": "count"},
+				{"func": "max", "field": "amount"},
+				{"func": "count_distinct", "field": "customer", "as": "customers"}
+			]
+		},
+		"size": 2,
+		"workers": 1
+	}]`)
+
+	if _, e := sy.DoSync("totals"); e != nil {
+		t.Fatal(e)
+	}
+
+	target, _ := ds.Connect("jsonl://" + dir + "/totals.jsonl")
+	res, _ := target.List(ds.ListOption{Orders: []ds.ListOrder{{Field: "shop_id", Order: "asc"}}})
+	totals := make([]string, len(res.Data))
+	for i, data := range res.Data {
+		row := data.(map[string]any)
+		totals[i] = fmt.Sprintf("%v:%v:%v:%v:%v", row["shop_id"], row["total"], row["count"], row["max_amount"], row["customers"])
+	}
+	if fmt.Sprint(totals) != "[a:20:3:10:2 b:6.5:2:5:2]" {
+		t.Fatalf("unexpected rows: %v", totals)
+	}
+}
+
+// schemaTarget a target describing its columns, writing nothing
+type schemaTarget struct {
+	columns []ds.Column
+}
+
+func (st *schemaTarget) SyncFrom(conf syncer.TargetConfig, data []map[string]any, meta *syncer.SyncMeta) error {
+	return nil
+}
+
+func (st *schemaTarget) BeforeSync(conf syncer.TargetConfig, meta *syncer.SyncMeta) error {
+	return nil
+}
+
+func (st *schemaTarget) AfterSync(conf syncer.TargetConfig, meta *syncer.SyncMeta) error {
+	return nil
+}
+
+func (st *schemaTarget) TargetSchema(conf syncer.TargetConfig) ([]ds.Column, error) {
+	return st.columns, nil
+}
+
+func TestAggregateDrift(t *testing.T) {
+	dir := fileFixture(t, map[string]string{
+		"orders.csv": "shop,amount,customer\na,10,x\n",
+	})
+
+	syncer.RegisterTarget("shop_totals", &schemaTarget{columns: []ds.Column{
+		{Name: "shop_id", Type: ds.TypeString},
+		{Name: "total", Type: ds.TypeFloat},
+	}})
+
+	sy := loadTasks(t, fmt.Sprintf(`[{
+		"id": "totals",
+		"source": "csv://%s/orders.csv",
+		"mapping": {"shop": "shop_id", "amount": "amount", "customer": "customer"},
+		"target": "shop_totals",
+		"aggregate": {
+			"group_by": ["shop_id"],
+			"aggregates": [{"func": "sum", "field": "amount", "as": "total"}, {"func": "count"}]
+		},
+		"size": 10,
+		"workers": 1
+	}]`, dir))
+
+	drifts, e := sy.Validate("totals")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(drifts) != 1 || drifts[0].Kind != syncer.DriftTargetMissing || drifts[0].Field != "count" {
+		t.Fatalf("expected only the count aggregate missing in the target, got %v", drifts)
+	}
+}
@@ -0,0 +1,777 @@
+package syncer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/enorith/syncer/ds"
+	jsoniter "github.com/json-iterator/go"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// ConfigExtensions file extensions of the formats a config can be written in
+	ConfigExtensions = []string{".json", ".yaml", ".yml", ".toml"}
+
+	configEnv       = regexp.MustCompile(`\$\{(\w+)(?::-([^}]*))?\}`)
+	configErrorLine = regexp.MustCompile(`line (\d+)`)
+)
+
+// Config tasks and templates loaded from config files
+type Config struct {
+	Tasks     []SyncerTask
+	Templates []TaskTemplate
+	// Files the loaded files, the root file first
+	Files []string
+}
+
+// ConfigIssue a problem found in a config file
+type ConfigIssue struct {
+	File    string
+	Line    int
+	Message string
+}
+
+func (i ConfigIssue) String() string {
+	return fmt.Sprintf("%s:%d: %s", i.File, i.Line, i.Message)
+}
+
+func (i *ConfigIssue) Error() string {
+	return i.String()
+}
+
+// ConfigError every issue found loading a config
+type ConfigError struct {
+	Issues []ConfigIssue
+}
+
+func (e *ConfigError) Error() string {
+	lines := make([]string, len(e.Issues))
+	for i, issue := range e.Issues {
+		lines[i] = issue.String()
+	}
+
+	return "[syncer] invalid config:\n" + strings.Join(lines, "\n")
+}
+
+// configNode a decoded value with the line it is defined at
+type configNode struct {
+	line   int
+	value  any
+	keys   []string
+	fields map[string]*configNode
+	items  []*configNode
+	isList bool
+}
+
+// plain the value of the node as maps, lists and scalars
+func (n *configNode) plain() any {
+	switch {
+	case n.fields != nil:
+		m := make(map[string]any, len(n.fields))
+		for key, field := range n.fields {
+			m[key] = field.plain()
+		}
+		return m
+	case n.isList:
+		list := make([]any, len(n.items))
+		for i, item := range n.items {
+			list[i] = item.plain()
+		}
+		return list
+	}
+
+	return n.value
+}
+
+func (n *configNode) fieldLine(key string) int {
+	if field, ok := n.fields[key]; ok {
+		return field.line
+	}
+
+	return n.line
+}
+
+// configEntry a task or template of a config file
+type configEntry struct {
+	template bool
+	file     string
+	node     *configNode
+}
+
+// decodedEntry a decoded task, or the task and tenants of a template
+type decodedEntry struct {
+	configEntry
+	task    SyncerTask
+	tenants *TenantConfig
+}
+
+type configLoader struct {
+	seen    map[string]bool
+	files   []string
+	entries []configEntry
+	decoded []decodedEntry
+	issues  []ConfigIssue
+}
+
+func (l *configLoader) issue(file string, line int, format string, args ...any) {
+	l.issues = append(l.issues, ConfigIssue{File: file, Line: line, Message: fmt.Sprintf(format, args...)})
+}
+
+// LoadConfig loads tasks and templates from a json, yaml or toml file. The file holds a list of tasks,
+// or an object of tasks, templates and include, files, globs or directories of more config files
+// relative to it. ${ENV} and ${ENV:-default} in strings are replaced with environment variables.
+// Every issue found is reported with its file and line in a ConfigError, targets and datasources
+// must be registered before loading
+func LoadConfig(path string) (*Config, error) {
+	l := &configLoader{seen: make(map[string]bool)}
+	if e := l.load(path, "", 0); e != nil {
+		return nil, e
+	}
+
+	conf := &Config{Files: l.files}
+	l.decode(conf)
+	l.validate()
+
+	if len(l.issues) > 0 {
+		sort.SliceStable(l.issues, func(i, j int) bool {
+			if l.issues[i].File != l.issues[j].File {
+				return l.issues[i].File < l.issues[j].File
+			}
+			return l.issues[i].Line < l.issues[j].Line
+		})
+		return conf, &ConfigError{Issues: l.issues}
+	}
+
+	return conf, nil
+}
+
+// load parses a file and the files it includes, a file which can not be read is an issue of the
+// file including it, an error for the root file
+func (l *configLoader) load(path, from string, fromLine int) error {
+	abs, e := filepath.Abs(path)
+	if e != nil {
+		return e
+	}
+	if l.seen[abs] {
+		return nil
+	}
+	l.seen[abs] = true
+
+	data, e := os.ReadFile(abs)
+	if e != nil {
+		if from == "" {
+			return e
+		}
+		l.issue(from, fromLine, "include %s: %v", path, e)
+		return nil
+	}
+	l.files = append(l.files, abs)
+
+	root, e := parseConfig(abs, data)
+	if e != nil {
+		var issue *ConfigIssue
+		if errors.As(e, &issue) {
+			l.issues = append(l.issues, *issue)
+			return nil
+		}
+		if from == "" {
+			return e
+		}
+		l.issue(from, fromLine, "include %s: %v", path, e)
+		return nil
+	}
+
+	if root.isList {
+		l.addEntries(abs, root, false)
+		return nil
+	}
+
+	if root.fields == nil {
+		l.issue(abs, root.line, "config must be a list of tasks or an object of tasks, templates and include")
+		return nil
+	}
+
+	for _, key := range root.keys {
+		node := root.fields[key]
+		switch key {
+		case "tasks":
+			l.addEntries(abs, node, false)
+		case "templates":
+			l.addEntries(abs, node, true)
+		case "include":
+		default:
+			l.issue(abs, node.line, "unknown config key: %s", key)
+		}
+	}
+
+	if include, ok := root.fields["include"]; ok {
+		patterns := []*configNode{include}
+		if include.isList {
+			patterns = include.items
+		}
+
+		for _, pattern := range patterns {
+			p, ok := pattern.value.(string)
+			if !ok {
+				l.issue(abs, pattern.line, "include must be a path or a list of paths")
+				continue
+			}
+
+			paths, e := includePaths(filepath.Dir(abs), p)
+			if e != nil {
+				l.issue(abs, pattern.line, "include %s: %v", p, e)
+				continue
+			}
+
+			for _, included := range paths {
+				if e := l.load(included, abs, pattern.line); e != nil {
+					return e
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (l *configLoader) addEntries(file string, node *configNode, template bool) {
+	if !node.isList {
+		l.issue(file, node.line, "tasks and templates must be lists")
+		return
+	}
+
+	for _, item := range node.items {
+		if item.fields == nil {
+			l.issue(file, item.line, "task must be an object")
+			continue
+		}
+		l.entries = append(l.entries, configEntry{template: template, file: file, node: item})
+	}
+}
+
+// includePaths the config files of an include, a directory includes the config files in it
+func includePaths(dir, pattern string) ([]string, error) {
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(dir, pattern)
+	}
+
+	if info, e := os.Stat(pattern); e == nil && info.IsDir() {
+		pattern = filepath.Join(pattern, "*")
+	} else if !strings.ContainsAny(pattern, "*?[") {
+		return []string{pattern}, e
+	}
+
+	matches, e := filepath.Glob(pattern)
+	if e != nil {
+		return nil, e
+	}
+
+	var paths []string
+	for _, match := range matches {
+		if info, e := os.Stat(match); e == nil && !info.IsDir() && isConfigFile(match) {
+			paths = append(paths, match)
+		}
+	}
+	sort.Strings(paths)
+
+	return paths, nil
+}
+
+func isConfigFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, configExt := range ConfigExtensions {
+		if ext == configExt {
+			return true
+		}
+	}
+
+	return false
+}
+
+// decode expands the environment variables of the entries and decodes them
+func (l *configLoader) decode(conf *Config) {
+	for _, entry := range l.entries {
+		l.expandEnv(entry.file, entry.node)
+
+		raw, e := jsoniter.Marshal(entry.node.plain())
+		if e != nil {
+			l.issue(entry.file, entry.node.line, "%v", e)
+			continue
+		}
+
+		if entry.template {
+			var tpl TaskTemplate
+			if e := jsoniter.Unmarshal(raw, &tpl); e != nil {
+				l.issue(entry.file, entry.node.line, "template can not be decoded: %v", e)
+				continue
+			}
+			tpl.SyncerTask = resolveConfigPaths(tpl.SyncerTask, entry.file)
+			conf.Templates = append(conf.Templates, tpl)
+			l.decoded = append(l.decoded, decodedEntry{configEntry: entry, task: tpl.SyncerTask, tenants: &tpl.Tenants})
+			continue
+		}
+
+		var task SyncerTask
+		if e := jsoniter.Unmarshal(raw, &task); e != nil {
+			l.issue(entry.file, entry.node.line, "task can not be decoded: %v", e)
+			continue
+		}
+		task = resolveConfigPaths(task, entry.file)
+		conf.Tasks = append(conf.Tasks, task)
+		l.decoded = append(l.decoded, decodedEntry{configEntry: entry, task: task})
+	}
+}
+
+func (l *configLoader) expandEnv(file string, node *configNode) {
+	if s, ok := node.value.(string); ok {
+		node.value = configEnv.ReplaceAllStringFunc(s, func(match string) string {
+			parts := configEnv.FindStringSubmatch(match)
+			if value, ok := os.LookupEnv(parts[1]); ok {
+				return value
+			}
+			if strings.Contains(match, ":-") {
+				return parts[2]
+			}
+
+			l.issue(file, node.line, "undefined environment variable: %s", parts[1])
+			return ""
+		})
+	}
+
+	for _, key := range node.keys {
+		l.expandEnv(file, node.fields[key])
+	}
+	for _, item := range node.items {
+		l.expandEnv(file, item)
+	}
+}
+
+// resolveConfigPaths resolves the script file of a task relative to its config file
+func resolveConfigPaths(task SyncerTask, file string) SyncerTask {
+	if task.Script != nil && task.Script.File != "" && !filepath.IsAbs(task.Script.File) {
+		script := *task.Script
+		script.File = filepath.Join(filepath.Dir(file), script.File)
+		task.Script = &script
+	}
+
+	return task
+}
+
+// validate reports duplicate ids, sizes and workers below one, unknown source schemes and targets,
+// and target configs their target rejects
+func (l *configLoader) validate() {
+	type defined struct {
+		file string
+		line int
+	}
+	ids := make(map[string]defined)
+
+	for _, entry := range l.decoded {
+		task, tenants := entry.task, entry.tenants
+		node, file := entry.node, entry.file
+
+		if task.ID == "" {
+			l.issue(file, node.line, "task id is required")
+		} else if first, ok := ids[task.ID]; ok {
+			l.issue(file, node.fieldLine("id"), "duplicate task id %s, first defined at %s:%d", task.ID, first.file, first.line)
+		} else {
+			ids[task.ID] = defined{file, node.fieldLine("id")}
+		}
+
+		if task.Size <= 0 {
+			l.issue(file, node.fieldLine("size"), "task %s: size must be greater than zero", task.ID)
+		}
+		if task.Workers <= 0 {
+			l.issue(file, node.fieldLine("workers"), "task %s: workers must be greater than zero", task.ID)
+		}
+
+		// urls of a template are checked as expanded for a tenant
+		checked := task
+		if tenants != nil {
+			checked = placeholderTask(task)
+		}
+
+		if e := checkScheme(checked.Source); e != nil {
+			l.issue(file, node.fieldLine("source"), "task %s: source %v", task.ID, e)
+		}
+
+		if tenants != nil {
+			if _, e := tenants.Provider(); e != nil {
+				l.issue(file, node.fieldLine("tenants"), "template %s: %v", task.ID, e)
+			} else if tenants.Source != "" {
+				if e := checkScheme(tenants.Source); e != nil {
+					l.issue(file, node.fieldLine("tenants"), "template %s: tenants source %v", task.ID, e)
+				}
+			}
+		}
+
+		targetLine, configLine := node.fieldLine("target"), node.fieldLine("target_config")
+		if len(task.Targets) > 0 {
+			targetLine, configLine = node.fieldLine("targets"), node.fieldLine("targets")
+		}
+
+		for _, tt := range checked.taskTargets() {
+			target, e := configTarget(tt)
+			if e != nil {
+				l.issue(file, targetLine, "task %s: %v", task.ID, e)
+				continue
+			}
+
+			if validator, ok := target.(ConfigValidator); ok {
+				if e := validator.ValidateConfig(tt.TargetConfig); e != nil {
+					l.issue(file, configLine, "task %s: target_config of %s: %v", task.ID, tt.Target, e)
+				}
+			}
+		}
+	}
+}
+
+// placeholderTask a template task with its placeholders replaced by a tenant id like value
+func placeholderTask(task SyncerTask) SyncerTask {
+	raw, e := jsoniter.Marshal(task)
+	if e != nil {
+		return task
+	}
+
+	var expanded SyncerTask
+	if e := jsoniter.Unmarshal(templateParam.ReplaceAll(raw, []byte("tenant")), &expanded); e != nil {
+		return task
+	}
+
+	return expanded
+}
+
+// checkScheme checks the scheme of a connection url is registered
+func checkScheme(conn string) error {
+	if conn == "" {
+		return errors.New("is required")
+	}
+
+	u, e := url.Parse(conn)
+	if e != nil {
+		return e
+	}
+
+	if _, ok := ds.GetRegister(u.Scheme); !ok {
+		return fmt.Errorf("has unknown scheme: %s", u.Scheme)
+	}
+
+	return nil
+}
+
+// configTarget the target of a task target, datasource urls are not connected, only their scheme is checked
+func configTarget(tt TaskTarget) (Target, error) {
+	if tt.Target == "" {
+		return nil, errors.New("target is required")
+	}
+
+	if target, ok := GetTarget(tt.Target); ok {
+		return target, nil
+	}
+
+	if !strings.Contains(tt.Target, "://") {
+		return nil, fmt.Errorf("unknown target: %s", tt.Target)
+	}
+
+	if e := checkScheme(tt.Target); e != nil {
+		return nil, fmt.Errorf("target %v", e)
+	}
+
+	return &DatasourceTarget{}, nil
+}
+
+// AddConfig adds the tasks and templates of a config
+func (s *Syncer) AddConfig(conf *Config) error {
+	if e := s.AddTasks(conf.Tasks...); e != nil {
+		return e
+	}
+
+	var errs []error
+	for _, tpl := range conf.Templates {
+		if _, e := s.AddTemplate(tpl); e != nil {
+			errs = append(errs, e)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// parseConfig parses a config file by its extension, syntax errors are returned as *ConfigIssue
+func parseConfig(path string, data []byte) (*configNode, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return parseJSONConfig(path, data)
+	case ".yaml", ".yml":
+		return parseYAMLConfig(path, data)
+	case ".toml":
+		return parseTOMLConfig(path, data)
+	}
+
+	return nil, fmt.Errorf("[syncer] unsupported config format: %s", path)
+}
+
+func parseJSONConfig(path string, data []byte) (*configNode, error) {
+	newlines := []int64{}
+	for i, b := range data {
+		if b == '\n' {
+			newlines = append(newlines, int64(i))
+		}
+	}
+	lineAt := func(offset int64) int {
+		return sort.Search(len(newlines), func(i int) bool { return newlines[i] >= offset }) + 1
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	node, e := jsonNode(dec, lineAt)
+	if e != nil {
+		line := lineAt(dec.InputOffset())
+		var syntax *json.SyntaxError
+		if errors.As(e, &syntax) {
+			line = lineAt(syntax.Offset)
+		}
+		return nil, &ConfigIssue{File: path, Line: line, Message: e.Error()}
+	}
+
+	return node, nil
+}
+
+func jsonNode(dec *json.Decoder, lineAt func(int64) int) (*configNode, error) {
+	tok, e := dec.Token()
+	if e != nil {
+		return nil, e
+	}
+
+	node := &configNode{line: lineAt(dec.InputOffset() - 1)}
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		node.value = tok
+		return node, nil
+	}
+
+	switch delim {
+	case '{':
+		node.fields = make(map[string]*configNode)
+		for dec.More() {
+			keyTok, e := dec.Token()
+			if e != nil {
+				return nil, e
+			}
+			key, _ := keyTok.(string)
+			line := lineAt(dec.InputOffset() - 1)
+
+			field, e := jsonNode(dec, lineAt)
+			if e != nil {
+				return nil, e
+			}
+			field.line = line
+
+			if _, ok := node.fields[key]; !ok {
+				node.keys = append(node.keys, key)
+			}
+			node.fields[key] = field
+		}
+	case '[':
+		node.isList = true
+		for dec.More() {
+			item, e := jsonNode(dec, lineAt)
+			if e != nil {
+				return nil, e
+			}
+			node.items = append(node.items, item)
+		}
+	}
+
+	// the closing delim
+	_, e = dec.Token()
+
+	return node, e
+}
+
+func parseYAMLConfig(path string, data []byte) (*configNode, error) {
+	var doc yaml.Node
+	if e := yaml.Unmarshal(data, &doc); e != nil {
+		line := 0
+		if m := configErrorLine.FindStringSubmatch(e.Error()); m != nil {
+			line, _ = strconv.Atoi(m[1])
+		}
+		return nil, &ConfigIssue{File: path, Line: line, Message: e.Error()}
+	}
+
+	if len(doc.Content) == 0 {
+		return &configNode{line: 1}, nil
+	}
+
+	return yamlNode(doc.Content[0])
+}
+
+func yamlNode(n *yaml.Node) (*configNode, error) {
+	if n.Kind == yaml.AliasNode {
+		return yamlNode(n.Alias)
+	}
+
+	node := &configNode{line: n.Line}
+	switch n.Kind {
+	case yaml.MappingNode:
+		node.fields = make(map[string]*configNode)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i].Value
+			field, e := yamlNode(n.Content[i+1])
+			if e != nil {
+				return nil, e
+			}
+			field.line = n.Content[i].Line
+
+			if _, ok := node.fields[key]; !ok {
+				node.keys = append(node.keys, key)
+			}
+			node.fields[key] = field
+		}
+	case yaml.SequenceNode:
+		node.isList = true
+		for _, item := range n.Content {
+			child, e := yamlNode(item)
+			if e != nil {
+				return nil, e
+			}
+			node.items = append(node.items, child)
+		}
+	default:
+		if e := n.Decode(&node.value); e != nil {
+			return nil, e
+		}
+	}
+
+	return node, nil
+}
+
+var (
+	tomlHeader = regexp.MustCompile(`^\s*\[\[?\s*([\w.-]+)\s*\]\]?`)
+	tomlKey    = regexp.MustCompile(`^\s*"?([\w-]+)"?\s*=`)
+)
+
+// parseTOMLConfig decodes a toml file, the lines of values are found from the table headers and keys
+// of the text, values inside tables without their own header take the line of the table
+func parseTOMLConfig(path string, data []byte) (*configNode, error) {
+	var root map[string]any
+	if _, e := toml.Decode(string(data), &root); e != nil {
+		line := 0
+		var parseErr toml.ParseError
+		if errors.As(e, &parseErr) {
+			line = parseErr.Position.Line
+		}
+		return nil, &ConfigIssue{File: path, Line: line, Message: e.Error()}
+	}
+
+	// lines of keys by the table they are in, "" the root table, "tasks#0" the first [[tasks]]
+	lines := make(map[string]map[string]int)
+	tables := make(map[string]int)
+	table := ""
+	for i, text := range strings.Split(string(data), "\n") {
+		line := i + 1
+		if m := tomlHeader.FindStringSubmatch(text); m != nil {
+			name, sub, _ := strings.Cut(m[1], ".")
+			if strings.HasPrefix(strings.TrimSpace(text), "[[") && sub == "" {
+				table = fmt.Sprintf("%s#%d", name, tables[name])
+				tables[name]++
+				setLine(lines, "", name, line)
+				setLine(lines, table, "", line)
+				continue
+			}
+
+			if sub != "" && tables[name] > 0 {
+				setLine(lines, fmt.Sprintf("%s#%d", name, tables[name]-1), strings.Split(sub, ".")[0], line)
+				continue
+			}
+
+			table = ""
+			setLine(lines, "", name, line)
+			continue
+		}
+
+		if m := tomlKey.FindStringSubmatch(text); m != nil {
+			setLine(lines, table, m[1], line)
+		}
+	}
+
+	node := tomlNode(root, 1)
+	for _, key := range node.keys {
+		field := node.fields[key]
+		if line, ok := lines[""][key]; ok {
+			setNodeLine(field, line)
+		}
+
+		for i, item := range field.items {
+			entry, ok := lines[fmt.Sprintf("%s#%d", key, i)]
+			if !ok {
+				continue
+			}
+			setNodeLine(item, entry[""])
+			for name, child := range item.fields {
+				if line, ok := entry[name]; ok {
+					setNodeLine(child, line)
+				}
+			}
+		}
+	}
+
+	return node, nil
+}
+
+func setLine(lines map[string]map[string]int, table, key string, line int) {
+	if lines[table] == nil {
+		lines[table] = make(map[string]int)
+	}
+	if _, ok := lines[table][key]; !ok {
+		lines[table][key] = line
+	}
+}
+
+func setNodeLine(node *configNode, line int) {
+	node.line = line
+	for _, field := range node.fields {
+		setNodeLine(field, line)
+	}
+	for _, item := range node.items {
+		setNodeLine(item, line)
+	}
+}
+
+func tomlNode(value any, line int) *configNode {
+	node := &configNode{line: line}
+	switch v := value.(type) {
+	case map[string]any:
+		node.fields = make(map[string]*configNode, len(v))
+		for key, item := range v {
+			node.keys = append(node.keys, key)
+			node.fields[key] = tomlNode(item, line)
+		}
+		sort.Strings(node.keys)
+	case []map[string]any:
+		node.isList = true
+		for _, item := range v {
+			node.items = append(node.items, tomlNode(item, line))
+		}
+	case []any:
+		node.isList = true
+		for _, item := range v {
+			node.items = append(node.items, tomlNode(item, line))
+		}
+	default:
+		node.value = value
+	}
+
+	return node
+}
@@ -0,0 +1,150 @@
+package syncer_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/enorith/syncer"
+	"github.com/enorith/syncer/ds"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(dir+"/tasks.d", 0755)
+	t.Setenv("SYNC_DIR", dir)
+
+	os.WriteFile(dir+"/syncer.json", []byte(`{
+	"include": ["tasks.d"],
+	"tasks": [{
+		"id": "users",
+		"source": "csv://${SYNC_DIR}/users.csv",
+		"target": "jsonl://${SYNC_DIR}/users.jsonl",
+		"script": {"file": "scripts/users.lua"},
+		"size": 10,
+		"workers": 1
+	}]
+}`), 0644)
+	os.WriteFile(dir+"/tasks.d/orders.yaml", []byte(`tasks:
+  - id: orders
+    source: csv://${SYNC_DIR}/orders.csv
+    target: jsonl://${SYNC_DIR}/orders.jsonl
+    target_config:
+      keys: [id]
+    size: 20
+    workers: 2
+templates:
+  - id: tenant_orders
+    source: csv://${SYNC_DIR}/orders_{{tenant}}.csv
+    target: jsonl://${SYNC_DIR}/out_{{tenant}}.jsonl
+    tenants:
+      list: [a, b]
+    size: 5
+    workers: 1
+  - id: tenant_db_orders
+    source: db://{{tenant}}/orders
+    target: db://{{ tenant }}_archive/orders
+    tenants:
+      list: [c]
+    size: 5
+    workers: 1
+`), 0644)
+	os.WriteFile(dir+"/tasks.d/items.toml", []byte(`[[tasks]]
+id = "items"
+source = "csv://${ITEMS_DIR:-/tmp}/items.csv"
+target = "jsonl://${SYNC_DIR}/items.jsonl"
+size = 5
+workers = 1
+`), 0644)
+
+	ds.RegisterDatasource("csv", ds.FileRegister)
+	ds.RegisterDatasource("jsonl", ds.FileRegister)
+	ds.RegisterDatasource("db", ds.DBRegister)
+
+	conf, e := syncer.LoadConfig(dir + "/syncer.json")
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if len(conf.Tasks) != 3 || len(conf.Templates) != 2 || len(conf.Files) != 3 {
+		t.Fatalf("unexpected config: %d tasks, %d templates, files %v", len(conf.Tasks), len(conf.Templates), conf.Files)
+	}
+
+	tasks := make(map[string]syncer.SyncerTask)
+	for _, task := range conf.Tasks {
+		tasks[task.ID] = task
+	}
+	if tasks["users"].Source != "csv://"+dir+"/users.csv" || tasks["items"].Source != "csv:///tmp/items.csv" {
+		t.Fatalf("unexpected sources: %s, %s", tasks["users"].Source, tasks["items"].Source)
+	}
+	if tasks["users"].Script.File != filepath.Join(dir, "scripts/users.lua") {
+		t.Fatalf("unexpected script file: %s", tasks["users"].Script.File)
+	}
+	if tasks["orders"].Workers != 2 || string(tasks["orders"].TargetConfig.RawData()) != `{"keys":["id"]}` {
+		t.Fatalf("unexpected orders task: %+v", tasks["orders"])
+	}
+
+	sy := syncer.NewSyncer()
+	if e := sy.AddConfig(conf); e != nil {
+		t.Fatal(e)
+	}
+	if ids := sy.GroupTasks("tenant_orders"); len(ids) != 2 {
+		t.Fatalf("unexpected template tasks: %v", ids)
+	}
+}
+
+func TestLoadConfigIssues(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(dir+"/syncer.yaml", []byte(`include: missing.json
+tasks:
+  - id: users
+    source: nosuch://db/users
+    target: nosuch_target
+    size: 0
+    workers: 1
+  - id: users
+    source: csv://users.csv
+    target: jsonl://users.jsonl
+    target_config:
+      keys: id
+    size: 1
+    workers: 1
+    script:
+      source: ${UNDEFINED_SYNC_VAR}
+`), 0644)
+
+	ds.RegisterDatasource("csv", ds.FileRegister)
+	ds.RegisterDatasource("jsonl", ds.FileRegister)
+
+	_, e := syncer.LoadConfig(dir + "/syncer.yaml")
+
+	var configErr *syncer.ConfigError
+	if !errors.As(e, &configErr) {
+		t.Fatalf("expected a config error, got %v", e)
+	}
+
+	var issues []string
+	for _, issue := range configErr.Issues {
+		issues = append(issues, strings.TrimPrefix(issue.String(), dir+"/"))
+	}
+
+	for _, want := range []string{
+		"syncer.yaml:1: include missing.json",
+		"syncer.yaml:4: task users: source has unknown scheme: nosuch",
+		"syncer.yaml:5: task users: unknown target: nosuch_target",
+		"syncer.yaml:6: task users: size must be greater than zero",
+		"syncer.yaml:8: duplicate task id users, first defined at",
+		"syncer.yaml:11: task users: target_config of jsonl://users.jsonl",
+		"syncer.yaml:16: undefined environment variable: UNDEFINED_SYNC_VAR",
+	} {
+		found := false
+		for _, issue := range issues {
+			found = found || strings.HasPrefix(issue, want)
+		}
+		if !found {
+			t.Errorf("missing issue %q in:\n%s", want, strings.Join(issues, "\n"))
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package syncer
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/enorith/syncer/ds"
+)
+
+type DatasourceTargetConfig struct {
+	// Keys rows are upserted by keys, the primary keys of the datasource by default when rows hold
+	// all of them, otherwise rows are created in bulk. Keys require a datasource implementing
+	// ds.Upserter, pages are written concurrently so a lookup before writing could insert twice
+	Keys []string `json:"keys"`
+}
+
+// DatasourceTarget writes synced rows into any datasource
+type DatasourceTarget struct {
+	source ds.Datasource
+}
+
+func (dt *DatasourceTarget) config(conf TargetConfig) (DatasourceTargetConfig, error) {
+	var config DatasourceTargetConfig
+	if e := conf.Unmarshal(&config); e != nil {
+		return config, e
+	}
+
+	// a config validated before connecting has no source to check
+	if _, ok := dt.source.(ds.Upserter); dt.source != nil && len(config.Keys) > 0 && !ok {
+		return config, fmt.Errorf("[target] keys %s require a datasource which upserts, %T does not",
+			strings.Join(config.Keys, ", "), dt.source)
+	}
+
+	return config, nil
+}
+
+func (dt *DatasourceTarget) SyncFrom(conf TargetConfig, data []map[string]any, meta *SyncMeta) error {
+	config, e := dt.config(conf)
+	if e != nil {
+		return e
+	}
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	if len(config.Keys) == 0 {
+		config.Keys = dt.primaryKeys(data[0])
+	}
+
+	if len(config.Keys) == 0 {
+		return dt.source.Create(data)
+	}
+
+	return dt.source.(ds.Upserter).Upsert(data, config.Keys...)
+}
+
+// primaryKeys the primary keys of an upserting datasource when the row holds all of them
+func (dt *DatasourceTarget) primaryKeys(row map[string]any) []string {
+	provider, ok := dt.source.(ds.KeyProvider)
+	if _, upserts := dt.source.(ds.Upserter); !ok || !upserts {
+		return nil
+	}
+
+	keys := provider.PrimaryKeys()
+	for _, key := range keys {
+		if _, ok := row[key]; !ok {
+			return nil
+		}
+	}
+
+	return keys
+}
+
+func (dt *DatasourceTarget) ValidateConfig(conf TargetConfig) error {
+	_, e := dt.config(conf)
+
+	return e
+}
+
+func (dt *DatasourceTarget) BeforeSync(conf TargetConfig, meta *SyncMeta) error {
+	_, e := dt.config(conf)
+
+	return e
+}
+
+func (dt *DatasourceTarget) AfterSync(conf TargetConfig, meta *SyncMeta) error {
+	return nil
+}
+
+func NewDatasourceTarget(source ds.Datasource) *DatasourceTarget {
+	return &DatasourceTarget{source: source}
+}
+
+// ResolveTarget returns a registered target, or wraps a datasource connection url such as db://local/users_copy
+func ResolveTarget(name string) (Target, error) {
+	if target, ok := GetTarget(name); ok {
+		return target, nil
+	}
+
+	if strings.Contains(name, "://") {
+		source, e := ds.Connect(name)
+		if e != nil {
+			return nil, e
+		}
+
+		return NewDatasourceTarget(source), nil
+	}
+
+	return nil, fmt.Errorf("[syncer] target not found: %s", name)
+}
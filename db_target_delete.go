@@ -0,0 +1,114 @@
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/enorith/supports/collection"
+	"github.com/enorith/syncer/ds"
+	"gorm.io/gorm"
+)
+
+const (
+	DeleteModeHard    = "hard"
+	DeleteModeSoft    = "soft"
+	DeleteModeArchive = "archive"
+
+	DefaultDeletedAtField = "deleted_at"
+)
+
+func (config DBTargetConfig) deletedAtField() string {
+	if config.DeletedAtField == "" {
+		return DefaultDeletedAtField
+	}
+
+	return config.DeletedAtField
+}
+
+func (config DBTargetConfig) archiveTable() string {
+	if config.ArchiveTable == "" {
+		return config.Table + "_history"
+	}
+
+	return config.ArchiveTable
+}
+
+func checkDeleteMode(config DBTargetConfig) error {
+	switch config.DeleteMode {
+	case "":
+		return nil
+	case DeleteModeHard, DeleteModeSoft, DeleteModeArchive:
+	default:
+		return fmt.Errorf("[target] unknown delete mode: %s", config.DeleteMode)
+	}
+
+	if config.VersionField == "" {
+		return errors.New("[target] delete mode requires version_field")
+	}
+
+	if collection.Contains(config.Uniques, config.VersionField) {
+		return errors.New("[target] delete mode requires uniques without the version field")
+	}
+
+	return nil
+}
+
+// propagateDeletes removes rows whose version was not bumped by the current run
+func (db *DBTarget) propagateDeletes(tx *gorm.DB, config DBTargetConfig, meta *SyncMeta) error {
+	session := func() *gorm.DB {
+		return tx.Session(&gorm.Session{NewDB: true})
+	}
+	staleScope := func(d *gorm.DB) *gorm.DB {
+		d = d.Table(config.Table).Where(fmt.Sprintf("%s < ?", quoteIdent(tx, config.VersionField)), meta.Version)
+		if config.DeleteMode == DeleteModeSoft {
+			d = d.Where(fmt.Sprintf("%s IS NULL", quoteIdent(tx, config.deletedAtField())))
+		}
+		return d
+	}
+
+	var stale int64
+	if e := session().Scopes(staleScope).Count(&stale).Error; e != nil {
+		return e
+	}
+
+	if stale == 0 {
+		return nil
+	}
+
+	if config.DeleteThreshold > 0 {
+		var total int64
+		totalTx := session().Table(config.Table)
+		if config.DeleteMode == DeleteModeSoft {
+			totalTx = totalTx.Where(fmt.Sprintf("%s IS NULL", quoteIdent(tx, config.deletedAtField())))
+		}
+
+		if e := totalTx.Count(&total).Error; e != nil {
+			return e
+		}
+
+		if ratio := float64(stale) * 100 / float64(total); ratio > config.DeleteThreshold {
+			return fmt.Errorf("[target] delete propagation aborted, %d of %d rows (%.2f%%) would be removed, threshold %.2f%%",
+				stale, total, ratio, config.DeleteThreshold)
+		}
+	}
+
+	model := ds.MapModel(config.Table)
+
+	switch config.DeleteMode {
+	case DeleteModeSoft:
+		return session().Model(&model).Scopes(staleScope).Update(config.deletedAtField(), time.Now()).Error
+	case DeleteModeArchive:
+		return session().Transaction(func(tx *gorm.DB) error {
+			e := tx.Exec(fmt.Sprintf("INSERT INTO %s SELECT * FROM %s WHERE %s < ?", quoteIdent(tx, config.archiveTable()),
+				quoteIdent(tx, config.Table), quoteIdent(tx, config.VersionField)), meta.Version).Error
+			if e != nil {
+				return e
+			}
+
+			return tx.Session(&gorm.Session{NewDB: true}).Scopes(staleScope).Delete(&model).Error
+		})
+	}
+
+	return session().Scopes(staleScope).Delete(&model).Error
+}
@@ -0,0 +1,93 @@
+package syncer_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/enorith/syncer"
+)
+
+func TestPropagateDeletes(t *testing.T) {
+	tests := []struct {
+		name    string
+		conf    map[string]any
+		results []any
+		meta    syncer.SyncMeta
+		want    []string
+		err     string
+	}{
+		{
+			name:    "hard",
+			conf:    map[string]any{"delete_mode": "hard"},
+			results: []any{int64(2)},
+			want:    []string{`DELETE FROM "users_copy" WHERE "version" < 3`},
+		},
+		{
+			name:    "soft",
+			conf:    map[string]any{"delete_mode": "soft"},
+			results: []any{int64(2)},
+			want:    []string{`UPDATE "users_copy" SET "deleted_at"=`, `WHERE "version" < 3 AND "deleted_at" IS NULL`},
+		},
+		{
+			name:    "archive",
+			conf:    map[string]any{"delete_mode": "archive"},
+			results: []any{int64(2)},
+			want: []string{
+				`INSERT INTO "users_copy_history" SELECT * FROM "users_copy" WHERE "version" < 3`,
+				`DELETE FROM "users_copy" WHERE "version" < 3`,
+			},
+		},
+		{
+			name:    "threshold",
+			conf:    map[string]any{"delete_mode": "hard", "delete_threshold": 50},
+			results: []any{int64(6), int64(10)},
+			err:     "6 of 10 rows (60.00%) would be removed",
+		},
+		{
+			name:    "failed pages",
+			conf:    map[string]any{"delete_mode": "hard"},
+			results: []any{int64(2)},
+			meta:    syncer.SyncMeta{FailedPages: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, recorder := dryRunDB(t, syncer.DialectPostgres, tt.results...)
+			target := syncer.NewDBTarget(db)
+
+			conf := map[string]any{"table": "users_copy", "uniques": []string{"id"}, "version_field": "version"}
+			for k, v := range tt.conf {
+				conf[k] = v
+			}
+
+			meta := tt.meta
+			meta.Version, meta.Status = 3, syncer.SyncStatusSuccess
+			e := target.AfterSync(targetConfig(t, conf), &meta)
+			if tt.err != "" {
+				if e == nil || !strings.Contains(e.Error(), tt.err) {
+					t.Fatalf("expected error %q, got %v", tt.err, e)
+				}
+			} else if e != nil {
+				t.Fatal(e)
+			}
+
+			var writes []string
+			for _, sql := range recorder.statements() {
+				if !strings.HasPrefix(sql, "SELECT") {
+					writes = append(writes, sql)
+				}
+			}
+
+			joined := strings.Join(writes, "\n")
+			for _, want := range tt.want {
+				if !strings.Contains(joined, want) {
+					t.Fatalf("expected %s in:\n%s", want, joined)
+				}
+			}
+			if len(tt.want) == 0 && len(writes) > 0 {
+				t.Fatalf("expected no writes, got:\n%s", joined)
+			}
+		})
+	}
+}
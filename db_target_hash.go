@@ -0,0 +1,113 @@
+package syncer
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"sync/atomic"
+
+	"github.com/enorith/syncer/ds"
+	"gorm.io/gorm"
+)
+
+// RowHash hashes the fields of a mapped row in key order, skipping the hash field itself
+func RowHash(row map[string]any, skip string) string {
+	keys := make([]string, 0, len(row))
+	for k := range row {
+		if k != skip {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	h := sha1.New()
+	for _, k := range keys {
+		h.Write([]byte(k))
+		if row[k] == nil {
+			h.Write([]byte{0, 'N'})
+		} else {
+			h.Write([]byte{0, 'V'})
+			h.Write([]byte(ds.ValueString(row[k])))
+		}
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func checkHash(config DBTargetConfig) error {
+	if len(config.keyFields()) == 0 {
+		return errors.New("[target] hash_field requires uniques to find stored rows")
+	}
+
+	if config.SkipUnchanged && config.DeleteMode != "" {
+		return errors.New("[target] skip_unchanged can not be used with delete_mode, unchanged rows would be deleted")
+	}
+
+	if config.SkipUnchanged && (config.SyncStatusField != "" || config.MaxVersion > 0) {
+		return errors.New("[target] skip_unchanged can not be used with sync_status_field or max_version, unchanged rows keep their version and would be flagged or deleted")
+	}
+
+	return nil
+}
+
+// skipUnchanged compares row hashes with the stored rows, unchanged rows are removed
+// from data and, without SkipUnchanged, bumped to the current version and sync time and
+// restored when soft deleted
+func (db *DBTarget) skipUnchanged(tx *gorm.DB, config DBTargetConfig, data []map[string]any, meta *SyncMeta) ([]map[string]any, error) {
+	keys := config.keyFields()
+
+	var stored []map[string]any
+	e := whereKeys(tx.Session(&gorm.Session{NewDB: true}).Table(config.Table), keys, data).
+		Select(append(append([]string{}, keys...), config.HashField)).Find(&stored).Error
+	if e != nil {
+		return nil, e
+	}
+
+	hashes := make(map[string]string, len(stored))
+	for _, row := range stored {
+		hashes[rowKey(row, keys)] = ds.ValueString(row[config.HashField])
+	}
+
+	var (
+		changed, unchanged []map[string]any
+		updated            int64
+	)
+	for _, row := range data {
+		hash, ok := hashes[rowKey(row, keys)]
+		switch {
+		case !ok:
+			changed = append(changed, row)
+		case hash != row[config.HashField]:
+			changed = append(changed, row)
+			updated++
+		default:
+			unchanged = append(unchanged, row)
+		}
+	}
+
+	if len(unchanged) > 0 && !config.SkipUnchanged {
+		bump := make(map[string]any)
+		for _, field := range []string{config.VersionField, config.SyncTimeField, config.SyncStatusField} {
+			if field != "" {
+				bump[field] = unchanged[0][field]
+			}
+		}
+		if config.DeleteMode == DeleteModeSoft {
+			bump[config.deletedAtField()] = nil
+		}
+
+		if len(bump) > 0 {
+			if e := whereKeys(tx.Session(&gorm.Session{NewDB: true}).Table(config.Table), keys, unchanged).Updates(bump).Error; e != nil {
+				return nil, e
+			}
+		}
+	}
+
+	atomic.AddInt64(&meta.Inserted, int64(len(changed))-updated)
+	atomic.AddInt64(&meta.Updated, updated)
+	atomic.AddInt64(&meta.Unchanged, int64(len(unchanged)))
+
+	return changed, nil
+}
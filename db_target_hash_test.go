@@ -0,0 +1,55 @@
+package syncer_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/enorith/syncer"
+)
+
+func TestSkipUnchangedConfig(t *testing.T) {
+	target := syncer.NewDBTarget(nil)
+
+	for _, extra := range []map[string]any{
+		{"delete_mode": "hard"},
+		{"sync_status_field": "sync_status"},
+		{"max_version": 3},
+	} {
+		conf := map[string]any{"table": "users_copy", "uniques": []string{"id"}, "version_field": "version", "hash_field": "hash", "skip_unchanged": true}
+		for k, v := range extra {
+			conf[k] = v
+		}
+
+		if e := target.ValidateConfig(targetConfig(t, conf)); e == nil || !strings.Contains(e.Error(), "skip_unchanged can not be used") {
+			t.Fatalf("expected skip_unchanged config error for %v, got %v", extra, e)
+		}
+
+		delete(conf, "skip_unchanged")
+		if e := target.ValidateConfig(targetConfig(t, conf)); e != nil {
+			t.Fatalf("expected %v valid without skip_unchanged, got %v", extra, e)
+		}
+	}
+}
+
+func TestUnchangedRowsRestored(t *testing.T) {
+	stored := []map[string]any{{"id": 1, "hash": syncer.RowHash(map[string]any{"id": 1, "name": "a"}, "hash")}}
+	db, recorder := dryRunDB(t, syncer.DialectPostgres, stored)
+	target := syncer.NewDBTarget(db)
+
+	conf := targetConfig(t, map[string]any{
+		"table": "users_copy", "uniques": []string{"id"}, "version_field": "version",
+		"hash_field": "hash", "delete_mode": "soft",
+	})
+	meta := &syncer.SyncMeta{Version: 3}
+	if e := target.SyncFrom(conf, []map[string]any{{"id": 1, "name": "a"}}, meta); e != nil {
+		t.Fatal(e)
+	}
+
+	sql := strings.Join(recorder.statements(), "\n")
+	if !strings.Contains(sql, `UPDATE "users_copy" SET "deleted_at"=NULL,"version"=3 WHERE`) || strings.Contains(sql, "INSERT") {
+		t.Fatalf("expected the unchanged row bumped and restored:\n%s", sql)
+	}
+	if meta.Unchanged != 1 {
+		t.Fatalf("expected 1 unchanged row, got %d", meta.Unchanged)
+	}
+}
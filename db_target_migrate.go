@@ -0,0 +1,200 @@
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/enorith/supports/collection"
+	"github.com/enorith/syncer/ds"
+	"gorm.io/gorm"
+)
+
+// systemColumns columns the sync modes write besides the mapped ones
+func (config DBTargetConfig) systemColumns() []ds.Column {
+	timeType := ds.TypeTime
+	if config.SyncTimeFmt != "" {
+		timeType = ds.TypeString
+	}
+
+	var columns []ds.Column
+	add := func(name, typ string, length int64) {
+		if name != "" {
+			columns = append(columns, ds.Column{Name: name, Type: typ, Length: length, Nullable: true})
+		}
+	}
+
+	add(config.VersionField, ds.TypeInt, 0)
+	add(config.SyncTimeField, timeType, 0)
+	add(config.SyncStatusField, ds.TypeInt, 0)
+	add(config.HashField, ds.TypeString, 40)
+
+	if config.DeleteMode == DeleteModeSoft {
+		add(config.deletedAtField(), ds.TypeTime, 0)
+	}
+
+	if config.Strategy == StrategySCD2 {
+		add(config.validFromField(), timeType, 0)
+		add(config.validToField(), timeType, 0)
+		add(config.CurrentField, ds.TypeInt, 0)
+	}
+
+	return columns
+}
+
+// MigrationPlan the DDL creating the table with a unique index on the uniques, or adding the
+// columns it misses, columns are the mapped columns, usually SyncMeta.Columns
+func (db *DBTarget) MigrationPlan(conf TargetConfig, columns []ds.Column) ([]string, error) {
+	var config DBTargetConfig
+	conf.Unmarshal(&config)
+
+	if config.Table == "" {
+		return nil, errors.New("[target] db table is required")
+	}
+
+	if len(columns) == 0 {
+		return nil, errors.New("[target] auto_migrate requires the mapped columns")
+	}
+
+	tx := db.newSession()
+	dialect := dialectOf(tx)
+	table := quoteIdent(tx, config.Table)
+
+	seen := make(map[string]bool)
+	columns = collection.Filter(append(append([]ds.Column{}, columns...), config.systemColumns()...), func(column ds.Column) bool {
+		name := strings.ToLower(column.Name)
+		if seen[name] {
+			return false
+		}
+		seen[name] = true
+		return true
+	})
+
+	if !tx.Migrator().HasTable(config.Table) {
+		definitions := collection.Map(columns, func(column ds.Column) string {
+			return quoteIdent(tx, column.Name) + " " + columnDDLType(dialect, column)
+		})
+		plan := []string{fmt.Sprintf("CREATE TABLE %s (%s)", table, strings.Join(definitions, ", "))}
+
+		unique, keys := "UNIQUE ", config.Uniques
+		if config.Strategy == StrategySCD2 {
+			unique, keys = "", config.keyFields()
+		}
+
+		if len(keys) > 0 {
+			index := quoteIdent(tx, fmt.Sprintf("idx_%s_%s", config.Table, strings.Join(keys, "_")))
+			plan = append(plan, fmt.Sprintf("CREATE %sINDEX %s ON %s (%s)", unique, index, table,
+				strings.Join(collection.Map(keys, func(key string) string { return quoteIdent(tx, key) }), ", ")))
+		}
+
+		return plan, nil
+	}
+
+	existing, e := tx.Migrator().ColumnTypes(config.Table)
+	if e != nil {
+		return nil, e
+	}
+
+	present := make(map[string]bool, len(existing))
+	for _, column := range existing {
+		present[strings.ToLower(column.Name())] = true
+	}
+
+	var plan []string
+	for _, column := range columns {
+		if !present[strings.ToLower(column.Name)] {
+			plan = append(plan, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, quoteIdent(tx, column.Name), columnDDLType(dialect, column)))
+		}
+	}
+
+	return plan, nil
+}
+
+// autoMigrate applies the migration plan, or prints it in dry run mode
+func (db *DBTarget) autoMigrate(conf TargetConfig, config DBTargetConfig, meta *SyncMeta) error {
+	plan, e := db.MigrationPlan(conf, meta.Columns)
+	if e != nil {
+		return e
+	}
+
+	if config.DryRun {
+		for _, ddl := range plan {
+			log.Printf("[target] dry run %s: %s", meta.TaskID, ddl)
+		}
+
+		return nil
+	}
+
+	return db.newSession().Transaction(func(tx *gorm.DB) error {
+		for _, ddl := range plan {
+			if e := tx.Exec(ddl).Error; e != nil {
+				return e
+			}
+		}
+
+		return nil
+	})
+}
+
+// columnDDLType column type of a normalized type in the dialect, strings and unknown types are
+// text, sized by the source length on mysql so they can be indexed
+func columnDDLType(dialect string, column ds.Column) string {
+	switch column.Type {
+	case ds.TypeInt:
+		if dialect == DialectSQLite {
+			return "INTEGER"
+		}
+		return "BIGINT"
+	case ds.TypeFloat:
+		switch dialect {
+		case DialectMySQL:
+			return "DOUBLE"
+		case DialectSQLite:
+			return "REAL"
+		}
+		return "DOUBLE PRECISION"
+	case ds.TypeDecimal:
+		switch {
+		case dialect == DialectSQLite:
+			return "NUMERIC"
+		case column.Precision > 0 && dialect == DialectPostgres:
+			return fmt.Sprintf("NUMERIC(%d,%d)", column.Precision, column.Scale)
+		case column.Precision > 0:
+			return fmt.Sprintf("DECIMAL(%d,%d)", column.Precision, column.Scale)
+		case dialect == DialectPostgres:
+			return "NUMERIC"
+		}
+		// mysql defaults to DECIMAL(10,0), an unknown size takes the widest one
+		return "DECIMAL(65,30)"
+	case ds.TypeDate:
+		return "DATE"
+	case ds.TypeTimeOfDay:
+		return "TIME"
+	case ds.TypeBool:
+		return "BOOLEAN"
+	case ds.TypeTime:
+		if dialect == DialectPostgres {
+			return "TIMESTAMP"
+		}
+		return "DATETIME"
+	case ds.TypeBytes:
+		if dialect == DialectPostgres {
+			return "BYTEA"
+		}
+		return "BLOB"
+	}
+
+	switch {
+	case dialect == DialectMySQL && column.Length > 16383:
+		return "LONGTEXT"
+	case dialect == DialectMySQL && column.Length > 0:
+		return fmt.Sprintf("VARCHAR(%d)", column.Length)
+	case dialect == DialectMySQL:
+		return "VARCHAR(255)"
+	case dialect == DialectPostgres && column.Length > 0:
+		return fmt.Sprintf("VARCHAR(%d)", column.Length)
+	}
+
+	return "TEXT"
+}
@@ -0,0 +1,182 @@
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/enorith/supports/collection"
+	"github.com/enorith/syncer/ds"
+	"gorm.io/gorm"
+)
+
+const (
+	StrategySCD2 = "scd2"
+
+	DefaultValidFromField = "valid_from"
+	DefaultValidToField   = "valid_to"
+)
+
+func (config DBTargetConfig) validFromField() string {
+	if config.ValidFromField == "" {
+		return DefaultValidFromField
+	}
+
+	return config.ValidFromField
+}
+
+func (config DBTargetConfig) validToField() string {
+	if config.ValidToField == "" {
+		return DefaultValidToField
+	}
+
+	return config.ValidToField
+}
+
+// compareColumns columns which make a row changed, the updates or every mapped non key column
+func (config DBTargetConfig) compareColumns(data []map[string]any) []string {
+	if len(config.Updates) > 0 {
+		return config.Updates
+	}
+
+	keys := config.keyFields()
+	seen := make(map[string]bool)
+	var columns []string
+	for _, row := range data {
+		for column := range row {
+			if !seen[column] && !collection.Contains(keys, column) {
+				seen[column] = true
+				columns = append(columns, column)
+			}
+		}
+	}
+	sort.Strings(columns)
+
+	return columns
+}
+
+func checkSCD2(config DBTargetConfig) error {
+	if len(config.keyFields()) == 0 {
+		return errors.New("[target] scd2 strategy requires uniques as natural key")
+	}
+
+	if config.VersionField == "" {
+		return errors.New("[target] scd2 strategy requires version_field to close missing keys")
+	}
+
+	if config.MaxVersion > 0 || config.SyncStatusField != "" {
+		return errors.New("[target] scd2 strategy can not be used with max_version or sync_status_field, they would delete or flag the history")
+	}
+
+	if config.DeleteMode != "" {
+		return errors.New("[target] scd2 strategy can not be used with delete_mode, missing keys are closed and deletes would remove the history")
+	}
+
+	return nil
+}
+
+// syncSCD2 compares a batch with the open rows of its keys, unchanged rows only get
+// the version bumped, changed rows are closed and inserted again as the open row
+func (db *DBTarget) syncSCD2(tx *gorm.DB, config DBTargetConfig, data []map[string]any, compares []string, meta *SyncMeta, now string) error {
+	keys := config.keyFields()
+	validTo := quoteIdent(tx, config.validToField())
+
+	var current []map[string]any
+	e := whereKeys(tx.Session(&gorm.Session{NewDB: true}).Table(config.Table), keys, data).Where(fmt.Sprintf("%s IS NULL", validTo)).Find(&current).Error
+	if e != nil {
+		return e
+	}
+
+	open := make(map[string]map[string]any, len(current))
+	for _, row := range current {
+		open[rowKey(row, keys)] = row
+	}
+
+	var inserts, closes, unchanged []map[string]any
+	for _, row := range data {
+		old, ok := open[rowKey(row, keys)]
+		if ok && !rowChanged(old, row, compares) {
+			unchanged = append(unchanged, row)
+			continue
+		}
+
+		if ok {
+			closes = append(closes, row)
+		}
+
+		row[config.validFromField()] = now
+		row[config.validToField()] = nil
+		if config.CurrentField != "" {
+			row[config.CurrentField] = 1
+		}
+		inserts = append(inserts, row)
+	}
+
+	e = tx.Transaction(func(tx *gorm.DB) error {
+		if len(closes) > 0 {
+			if e := whereKeys(tx.Session(&gorm.Session{NewDB: true}).Table(config.Table), keys, closes).
+				Where(fmt.Sprintf("%s IS NULL", validTo)).Updates(config.closeValues(now)).Error; e != nil {
+				return e
+			}
+		}
+
+		if len(unchanged) > 0 {
+			bump := map[string]any{config.VersionField: meta.Version}
+			if config.SyncTimeField != "" {
+				bump[config.SyncTimeField] = now
+			}
+
+			if e := whereKeys(tx.Session(&gorm.Session{NewDB: true}).Table(config.Table), keys, unchanged).
+				Where(fmt.Sprintf("%s IS NULL", validTo)).Updates(bump).Error; e != nil {
+				return e
+			}
+		}
+
+		if len(inserts) == 0 {
+			return nil
+		}
+
+		return createInBatches(tx, config, config.Table, inserts)
+	})
+
+	if e == nil {
+		atomic.AddInt64(&meta.Inserted, int64(len(inserts)-len(closes)))
+		atomic.AddInt64(&meta.Updated, int64(len(closes)))
+		atomic.AddInt64(&meta.Unchanged, int64(len(unchanged)))
+	}
+
+	return e
+}
+
+// closeMissing closes the open rows whose keys were not seen by the run
+func (db *DBTarget) closeMissing(tx *gorm.DB, config DBTargetConfig, meta *SyncMeta) error {
+	timeFmt := DefaultTimeFormat
+	if config.SyncTimeFmt != "" {
+		timeFmt = config.SyncTimeFmt
+	}
+
+	return tx.Table(config.Table).
+		Where(fmt.Sprintf("%s IS NULL AND %s < ?", quoteIdent(tx, config.validToField()), quoteIdent(tx, config.VersionField)), meta.Version).
+		Updates(config.closeValues(time.Now().Format(timeFmt))).Error
+}
+
+func (config DBTargetConfig) closeValues(now string) map[string]any {
+	values := map[string]any{config.validToField(): now}
+	if config.CurrentField != "" {
+		values[config.CurrentField] = 0
+	}
+
+	return values
+}
+
+func rowChanged(old, row map[string]any, columns []string) bool {
+	for _, column := range columns {
+		if ds.CompareValues(old[column], row[column]) != 0 {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,64 @@
+package syncer_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/enorith/syncer"
+)
+
+func scd2Config(t *testing.T, extra map[string]any) syncer.TargetConfig {
+	conf := map[string]any{"table": "users_hist", "strategy": "scd2", "uniques": []string{"id"}, "version_field": "version"}
+	for k, v := range extra {
+		conf[k] = v
+	}
+
+	return targetConfig(t, conf)
+}
+
+func TestSCD2WriteBatchSize(t *testing.T) {
+	db, recorder := dryRunDB(t, syncer.DialectPostgres)
+	target := syncer.NewDBTarget(db)
+
+	rows := []map[string]any{{"id": 1, "name": "a"}, {"id": 2, "name": "b"}, {"id": 3, "name": "c"}}
+	if e := target.SyncFrom(scd2Config(t, map[string]any{"write_batch_size": 2}), rows, &syncer.SyncMeta{Version: 1}); e != nil {
+		t.Fatal(e)
+	}
+
+	var inserts int
+	for _, sql := range recorder.statements() {
+		if strings.HasPrefix(sql, `INSERT INTO "users_hist"`) {
+			inserts++
+		}
+	}
+	if inserts != 2 {
+		t.Fatalf("expected 2 insert statements, got %d: %v", inserts, recorder.statements())
+	}
+}
+
+func TestSCD2Config(t *testing.T) {
+	target := syncer.NewDBTarget(nil)
+
+	for _, extra := range []map[string]any{{"max_version": 3}, {"sync_status_field": "sync_status"}, {"delete_mode": "hard"}, {"delete_mode": "archive"}} {
+		if e := target.ValidateConfig(scd2Config(t, extra)); e == nil || !strings.Contains(e.Error(), "scd2 strategy can not be used") {
+			t.Fatalf("expected scd2 config error for %v, got %v", extra, e)
+		}
+	}
+}
+
+func TestSCD2SkipsCloseOnFailedPages(t *testing.T) {
+	for _, failed := range []int64{0, 1} {
+		db, recorder := dryRunDB(t, syncer.DialectPostgres)
+		target := syncer.NewDBTarget(db)
+
+		meta := &syncer.SyncMeta{Version: 2, Status: syncer.SyncStatusSuccess, FailedPages: failed}
+		if e := target.AfterSync(scd2Config(t, nil), meta); e != nil {
+			t.Fatal(e)
+		}
+
+		closed := strings.Contains(strings.Join(recorder.statements(), "\n"), `UPDATE "users_hist" SET "valid_to"=`)
+		if closed != (failed == 0) {
+			t.Fatalf("failed pages %d: expected closing missing keys %v, got %v", failed, failed == 0, recorder.statements())
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+const (
+	StrategyUpsert = "upsert"
+	StrategySwap   = "swap"
+)
+
+func (config DBTargetConfig) shadowTable() string {
+	return config.Table + "_shadow"
+}
+
+func (config DBTargetConfig) previousTable() string {
+	return config.Table + "_previous"
+}
+
+// writeTable is the table SyncFrom writes to, the shadow table with the swap strategy
+func (config DBTargetConfig) writeTable() string {
+	if config.Strategy == StrategySwap {
+		return config.shadowTable()
+	}
+
+	return config.Table
+}
+
+// checkSwap rejects the modes which expect rows of previous runs in the table, the shadow table only
+// holds the rows of the run
+func checkSwap(config DBTargetConfig) error {
+	if config.DeleteMode != "" {
+		return errors.New("[target] swap strategy can not be used with delete_mode, rows not synced are gone after the swap")
+	}
+
+	if config.ValidFromField != "" || config.ValidToField != "" || config.CurrentField != "" {
+		return errors.New("[target] swap strategy can not be used with scd2 fields, history is gone after the swap")
+	}
+
+	return nil
+}
+
+// createShadow recreates an empty shadow table with the schema of the target table
+func (db *DBTarget) createShadow(config DBTargetConfig) error {
+	tx := db.newSession()
+	table, shadow := quoteIdent(tx, config.Table), quoteIdent(tx, config.shadowTable())
+
+	if e := tx.Migrator().DropTable(config.shadowTable()); e != nil {
+		return e
+	}
+
+	switch dialectOf(tx) {
+	case DialectMySQL:
+		return tx.Exec(fmt.Sprintf("CREATE TABLE %s LIKE %s", shadow, table)).Error
+	case DialectPostgres:
+		if e := tx.Exec(fmt.Sprintf("CREATE TABLE %s (LIKE %s INCLUDING ALL)", shadow, table)).Error; e != nil {
+			return e
+		}
+
+		return db.pgIdentityColumns(config)
+	case DialectSQLite:
+		var ddl string
+		e := tx.Raw("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?", config.Table).Scan(&ddl).Error
+		if e != nil {
+			return e
+		}
+
+		if ddl == "" {
+			return fmt.Errorf("[target] table not found: %s", config.Table)
+		}
+
+		return tx.Exec(strings.Replace(ddl, ddlTableName(ddl), shadow, 1)).Error
+	}
+
+	return tx.Exec(fmt.Sprintf("CREATE TABLE %s AS SELECT * FROM %s WHERE 1 = 0", shadow, table)).Error
+}
+
+// pgIdentityColumns turns the serial columns of the shadow table into identity columns, LIKE copies
+// their defaults with the sequences of the target table, which are dropped with the previous table
+func (db *DBTarget) pgIdentityColumns(config DBTargetConfig) error {
+	tx := db.newSession()
+
+	var columns []string
+	e := tx.Table("information_schema.columns").
+		Where("table_schema = current_schema() AND table_name = ? AND column_default LIKE ?", config.shadowTable(), "nextval(%").
+		Order("ordinal_position").Pluck("column_name", &columns).Error
+	if e != nil {
+		return e
+	}
+
+	for _, column := range columns {
+		var last []int64
+		e := db.newSession().Table(config.Table).Pluck(fmt.Sprintf("COALESCE(MAX(%s), 0)", quoteIdent(tx, column)), &last).Error
+		if e != nil {
+			return e
+		}
+
+		start := int64(1)
+		if len(last) > 0 {
+			start = last[0] + 1
+		}
+
+		e = db.newSession().Exec(fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s DROP DEFAULT, ALTER COLUMN %[2]s ADD GENERATED BY DEFAULT AS IDENTITY (START WITH %d)",
+			quoteIdent(tx, config.shadowTable()), quoteIdent(tx, column), start)).Error
+		if e != nil {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// swapShadow replaces the target table with the shadow table, keeping the replaced table as
+// <table>_previous when KeepPrevious is set. A run with failed pages is not swapped in
+func (db *DBTarget) swapShadow(config DBTargetConfig, meta *SyncMeta) error {
+	if !meta.Complete() {
+		if e := db.dropShadow(config); e != nil {
+			return e
+		}
+
+		return fmt.Errorf("[target] swap of %s refused, %d pages of the run failed", config.Table, meta.FailedPages)
+	}
+
+	tx := db.newSession()
+	table, shadow, previous := quoteIdent(tx, config.Table), quoteIdent(tx, config.shadowTable()), quoteIdent(tx, config.previousTable())
+
+	if e := tx.Migrator().DropTable(config.previousTable()); e != nil {
+		return e
+	}
+
+	var e error
+	if dialectOf(tx) == DialectMySQL {
+		e = tx.Exec(fmt.Sprintf("RENAME TABLE %s TO %s, %s TO %s", table, previous, shadow, table)).Error
+	} else {
+		e = tx.Transaction(func(tx *gorm.DB) error {
+			if e := tx.Exec(fmt.Sprintf("ALTER TABLE %s RENAME TO %s", table, previous)).Error; e != nil {
+				return e
+			}
+
+			return tx.Exec(fmt.Sprintf("ALTER TABLE %s RENAME TO %s", shadow, table)).Error
+		})
+	}
+
+	if e != nil || config.KeepPrevious {
+		return e
+	}
+
+	return db.newSession().Migrator().DropTable(config.previousTable())
+}
+
+func (db *DBTarget) dropShadow(config DBTargetConfig) error {
+	return db.newSession().Migrator().DropTable(config.shadowTable())
+}
+
+// ddlTableName extracts the (possibly quoted) table name of a CREATE TABLE statement
+func ddlTableName(ddl string) string {
+	fields := strings.Fields(ddl)
+	for i, field := range fields {
+		if strings.EqualFold(field, "TABLE") && i+1 < len(fields) {
+			name := fields[i+1]
+			if strings.EqualFold(name, "IF") && i+4 < len(fields) {
+				name = fields[i+4]
+			}
+
+			return strings.SplitN(name, "(", 2)[0]
+		}
+	}
+
+	return ""
+}
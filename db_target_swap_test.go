@@ -0,0 +1,58 @@
+package syncer_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/enorith/syncer"
+)
+
+func TestSwapShadowIdentity(t *testing.T) {
+	db, recorder := dryRunDB(t, syncer.DialectPostgres, []string{"id"}, []int64{41})
+	target := syncer.NewDBTarget(db)
+
+	conf := targetConfig(t, map[string]any{"table": "users_copy", "strategy": "swap"})
+	if e := target.BeforeSync(conf, &syncer.SyncMeta{}); e != nil {
+		t.Fatal(e)
+	}
+
+	sql := strings.Join(recorder.statements(), "\n")
+	for _, want := range []string{
+		`CREATE TABLE "users_copy_shadow" (LIKE "users_copy" INCLUDING ALL)`,
+		`SELECT COALESCE(MAX("id"), 0) FROM "users_copy"`,
+		`ALTER TABLE "users_copy_shadow" ALTER COLUMN "id" DROP DEFAULT, ALTER COLUMN "id" ADD GENERATED BY DEFAULT AS IDENTITY (START WITH 42)`,
+	} {
+		if !strings.Contains(sql, want) {
+			t.Fatalf("expected %s in:\n%s", want, sql)
+		}
+	}
+}
+
+func TestSwapRefusedOnFailedPages(t *testing.T) {
+	db, recorder := dryRunDB(t, syncer.DialectPostgres)
+	target := syncer.NewDBTarget(db)
+
+	conf := targetConfig(t, map[string]any{"table": "users_copy", "strategy": "swap"})
+	e := target.AfterSync(conf, &syncer.SyncMeta{Status: syncer.SyncStatusSuccess, FailedPages: 2})
+	if e == nil || !strings.Contains(e.Error(), "2 pages of the run failed") {
+		t.Fatalf("expected refused swap, got %v", e)
+	}
+
+	sql := strings.Join(recorder.statements(), "\n")
+	if strings.Contains(sql, "RENAME") || !strings.Contains(sql, `DROP TABLE IF EXISTS "users_copy_shadow"`) {
+		t.Fatalf("expected the shadow table dropped without a swap:\n%s", sql)
+	}
+}
+
+func TestSwapConfig(t *testing.T) {
+	target := syncer.NewDBTarget(nil)
+
+	for _, conf := range []map[string]any{
+		{"table": "users_copy", "strategy": "swap", "version_field": "version", "delete_mode": "hard"},
+		{"table": "users_copy", "strategy": "swap", "valid_from_field": "valid_from"},
+	} {
+		if e := target.ValidateConfig(targetConfig(t, conf)); e == nil || !strings.Contains(e.Error(), "swap strategy") {
+			t.Fatalf("expected swap config error for %v, got %v", conf, e)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package syncer
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+const (
+	TransactionNone = "none"
+	TransactionPage = "page"
+	TransactionRun  = "run"
+
+	// MaxPlaceholders bind parameters allowed in one statement by mysql and postgres
+	MaxPlaceholders = 65535
+)
+
+var isolationLevels = map[string]sql.IsolationLevel{
+	"":                 sql.LevelDefault,
+	"read_uncommitted": sql.LevelReadUncommitted,
+	"read_committed":   sql.LevelReadCommitted,
+	"repeatable_read":  sql.LevelRepeatableRead,
+	"serializable":     sql.LevelSerializable,
+}
+
+// runTx transaction shared by the pages of a run, pages write one at a time on it
+type runTx struct {
+	mu     sync.Mutex
+	tx     *gorm.DB
+	failed error
+}
+
+func checkTransaction(config DBTargetConfig) error {
+	switch config.Transaction {
+	case "", TransactionNone, TransactionPage:
+	case TransactionRun:
+		if config.Strategy == StrategySwap {
+			return errors.New("[target] run transaction can not be used with swap strategy, the swap is already atomic")
+		}
+	default:
+		return fmt.Errorf("[target] unknown transaction: %s", config.Transaction)
+	}
+
+	if _, ok := isolationLevels[strings.ToLower(config.IsolationLevel)]; !ok {
+		return fmt.Errorf("[target] unknown isolation level: %s", config.IsolationLevel)
+	}
+
+	return nil
+}
+
+func (config DBTargetConfig) txOptions() *sql.TxOptions {
+	return &sql.TxOptions{Isolation: isolationLevels[strings.ToLower(config.IsolationLevel)]}
+}
+
+// writeBatchSize rows per insert statement, the configured size capped by the placeholder limit
+func (config DBTargetConfig) writeBatchSize(data []map[string]any) int {
+	columns := 1
+	for _, row := range data {
+		if len(row) > columns {
+			columns = len(row)
+		}
+	}
+
+	size := MaxPlaceholders / columns
+	if config.WriteBatchSize > 0 && config.WriteBatchSize < size {
+		size = config.WriteBatchSize
+	}
+
+	return size
+}
+
+// createInBatches inserts data with one statement per write batch
+func createInBatches(tx *gorm.DB, config DBTargetConfig, table string, data []map[string]any, scopes ...func(*gorm.DB) *gorm.DB) error {
+	size := config.writeBatchSize(data)
+	for start := 0; start < len(data); start += size {
+		end := min(start+size, len(data))
+
+		e := tx.Session(&gorm.Session{NewDB: true}).Table(table).Scopes(scopes...).Create(data[start:end]).Error
+		if e != nil {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// write runs fn in the transaction mode of config, a page transaction per call or the transaction of the run
+func (db *DBTarget) write(config DBTargetConfig, meta *SyncMeta, fn func(tx *gorm.DB) error) error {
+	switch config.Transaction {
+	case TransactionPage:
+		return db.newSession().Transaction(fn, config.txOptions())
+	case TransactionRun:
+		run, ok := db.runTx(meta)
+		if !ok {
+			return errors.New("[target] run transaction is not open")
+		}
+
+		run.mu.Lock()
+		defer run.mu.Unlock()
+
+		e := fn(run.tx)
+		if e != nil && run.failed == nil {
+			run.failed = e
+		}
+
+		return e
+	}
+
+	return fn(db.newSession())
+}
+
+func (db *DBTarget) beginRun(config DBTargetConfig, meta *SyncMeta) error {
+	tx := db.newSession().Begin(config.txOptions())
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.runs == nil {
+		db.runs = make(map[*SyncMeta]*runTx)
+	}
+	db.runs[meta] = &runTx{tx: tx}
+
+	return nil
+}
+
+func (db *DBTarget) runTx(meta *SyncMeta) (*runTx, bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	run, ok := db.runs[meta]
+
+	return run, ok
+}
+
+// endRun commits the run transaction when the run and the after sync work succeeded, otherwise rolls it back
+func (db *DBTarget) endRun(meta *SyncMeta, e error) error {
+	db.mu.Lock()
+	run, ok := db.runs[meta]
+	delete(db.runs, meta)
+	db.mu.Unlock()
+
+	if !ok {
+		return e
+	}
+
+	run.mu.Lock()
+	defer run.mu.Unlock()
+
+	if e == nil && run.failed != nil {
+		e = fmt.Errorf("[target] run transaction rolled back: %w", run.failed)
+	}
+
+	if e != nil || meta.Status != SyncStatusSuccess {
+		if re := run.tx.Rollback().Error; re != nil && e == nil {
+			return re
+		}
+
+		return e
+	}
+
+	return run.tx.Commit().Error
+}
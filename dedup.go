@@ -0,0 +1,283 @@
+package syncer
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/enorith/supports/collection"
+	"github.com/enorith/syncer/ds"
+)
+
+const (
+	DedupScopePage = "page"
+	DedupScopeRun  = "run"
+
+	DedupWinnerFirst = "first"
+	DedupWinnerLast  = "last"
+	DedupWinnerMax   = "max"
+
+	DefaultDedupSpillAfter = 100000
+)
+
+// DedupConfig deduplicates the rows of each target by key before they are written
+type DedupConfig struct {
+	// Keys defaults to the uniques (without the version field) or keys of the target config
+	Keys []string `json:"keys"`
+	// Scope page (default), or run, which also skips keys written by an earlier page of the run,
+	// pages of a run scope are written by one worker in page order
+	Scope string `json:"scope"`
+	// Winner first (default), last, or max of Column, picks the row of a key within a page,
+	// the run scope only supports first, rows of earlier pages are written already
+	Winner string `json:"winner"`
+	Column string `json:"column"`
+	// SpillAfter keys a run scope holds in memory before spilling them to disk
+	SpillAfter int `json:"spill_after"`
+}
+
+type deduper struct {
+	conf DedupConfig
+	keys []string
+	seen *keySet
+}
+
+func newDeduper(conf DedupConfig, tt TaskTarget) (*deduper, error) {
+	switch conf.Winner {
+	case "", DedupWinnerFirst, DedupWinnerLast:
+	case DedupWinnerMax:
+		if conf.Column == "" {
+			return nil, errors.New("[syncer] dedup max winner requires column")
+		}
+	default:
+		return nil, fmt.Errorf("[syncer] unknown dedup winner: %s", conf.Winner)
+	}
+
+	keys := conf.Keys
+	if len(keys) == 0 {
+		var config struct {
+			Uniques      []string `json:"uniques"`
+			Keys         []string `json:"keys"`
+			VersionField string   `json:"version_field"`
+		}
+		tt.TargetConfig.Unmarshal(&config)
+
+		keys = append(config.Uniques, config.Keys...)
+		keys = collection.Filter(keys, func(key string) bool {
+			return key != config.VersionField
+		})
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("[syncer] dedup of target %s requires keys or target uniques", tt.Target)
+	}
+
+	d := &deduper{conf: conf, keys: keys}
+
+	switch conf.Scope {
+	case "", DedupScopePage:
+	case DedupScopeRun:
+		if conf.Winner != "" && conf.Winner != DedupWinnerFirst {
+			return nil, fmt.Errorf("[syncer] dedup winner %s can not be used with the run scope, earlier pages are written before later ones are read", conf.Winner)
+		}
+		spillAfter := conf.SpillAfter
+		if spillAfter <= 0 {
+			spillAfter = DefaultDedupSpillAfter
+		}
+		d.seen = newKeySet(spillAfter)
+	default:
+		return nil, fmt.Errorf("[syncer] unknown dedup scope: %s", conf.Scope)
+	}
+
+	return d, nil
+}
+
+// dedup keeps the winner row of each key in the order keys first appear, then drops
+// keys written by earlier pages with the run scope
+func (d *deduper) dedup(rows []map[string]any) ([]map[string]any, error) {
+	index := make(map[string]int, len(rows))
+	var winners []map[string]any
+	var keys []string
+	for _, row := range rows {
+		k := rowKey(row, d.keys)
+		i, ok := index[k]
+		if !ok {
+			index[k] = len(winners)
+			winners = append(winners, row)
+			keys = append(keys, k)
+			continue
+		}
+
+		switch d.conf.Winner {
+		case DedupWinnerLast:
+			winners[i] = row
+		case DedupWinnerMax:
+			if ds.CompareValues(row[d.conf.Column], winners[i][d.conf.Column]) > 0 {
+				winners[i] = row
+			}
+		}
+	}
+
+	if d.seen == nil {
+		return winners, nil
+	}
+
+	fresh, e := d.seen.addNew(keys)
+	if e != nil {
+		return nil, e
+	}
+
+	var out []map[string]any
+	for i, row := range winners {
+		if fresh[i] {
+			out = append(out, row)
+		}
+	}
+
+	return out, nil
+}
+
+func (d *deduper) close() error {
+	if d == nil || d.seen == nil {
+		return nil
+	}
+
+	return d.seen.close()
+}
+
+const keyHashSize = 16
+
+// keySet set of key hashes held in memory up to a limit, then spilled to bucket files by the first
+// byte of the hash, so a lookup only reads the buckets its keys fall in
+type keySet struct {
+	mu      sync.Mutex
+	limit   int
+	memory  map[string]struct{}
+	dir     string
+	spilled bool
+}
+
+// addNew adds distinct keys, reporting which of them were not in the set
+func (s *keySet) addNew(keys []string) ([]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hashes := make([]string, len(keys))
+	fresh := make([]bool, len(keys))
+	buckets := make(map[byte][]int)
+	for i, key := range keys {
+		sum := sha1.Sum([]byte(key))
+		hashes[i] = string(sum[:keyHashSize])
+
+		if _, ok := s.memory[hashes[i]]; ok {
+			continue
+		}
+		fresh[i] = true
+		if s.spilled {
+			buckets[sum[0]] = append(buckets[sum[0]], i)
+		}
+	}
+
+	for bucket, indexes := range buckets {
+		stored, e := s.readBucket(bucket)
+		if e != nil {
+			return nil, e
+		}
+
+		for _, i := range indexes {
+			if _, ok := stored[hashes[i]]; ok {
+				fresh[i] = false
+			}
+		}
+	}
+
+	for i, hash := range hashes {
+		if fresh[i] {
+			s.memory[hash] = struct{}{}
+		}
+	}
+
+	if len(s.memory) > s.limit {
+		return fresh, s.spill()
+	}
+
+	return fresh, nil
+}
+
+func (s *keySet) spill() error {
+	if s.dir == "" {
+		dir, e := os.MkdirTemp("", "syncer-dedup-")
+		if e != nil {
+			return e
+		}
+		s.dir = dir
+	}
+
+	buckets := make(map[byte][]byte)
+	for hash := range s.memory {
+		buckets[hash[0]] = append(buckets[hash[0]], hash...)
+	}
+
+	for bucket, data := range buckets {
+		f, e := os.OpenFile(s.bucketPath(bucket), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if e != nil {
+			return e
+		}
+
+		_, e = f.Write(data)
+		if ce := f.Close(); e == nil {
+			e = ce
+		}
+		if e != nil {
+			return e
+		}
+	}
+
+	s.memory = make(map[string]struct{})
+	s.spilled = true
+
+	return nil
+}
+
+func (s *keySet) readBucket(bucket byte) (map[string]struct{}, error) {
+	f, e := os.Open(s.bucketPath(bucket))
+	if os.IsNotExist(e) {
+		return nil, nil
+	}
+	if e != nil {
+		return nil, e
+	}
+	defer f.Close()
+
+	stored := make(map[string]struct{})
+	r := bufio.NewReader(f)
+	hash := make([]byte, keyHashSize)
+	for {
+		if _, e := io.ReadFull(r, hash); e == io.EOF {
+			return stored, nil
+		} else if e != nil {
+			return nil, e
+		}
+		stored[string(hash)] = struct{}{}
+	}
+}
+
+func (s *keySet) bucketPath(bucket byte) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%02x", bucket))
+}
+
+func (s *keySet) close() error {
+	if s.dir == "" {
+		return nil
+	}
+
+	return os.RemoveAll(s.dir)
+}
+
+func newKeySet(limit int) *keySet {
+	return &keySet{limit: limit, memory: make(map[string]struct{})}
+}
@@ -0,0 +1,56 @@
+package syncer_test
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/enorith/syncer/ds"
+)
+
+func TestDedup(t *testing.T) {
+	sy, dir := fileTasks(t, map[string]string{
+		"scores.csv": "id,score\n1,5\n1,9\n2,1\n1,3\n3,4\n2,8\n",
+	}, `[{
+		"id": "scores",
+		"source": "csv://%[1]s/scores.csv",
+		"mapping": {"id": "uid", "score": "score"},
+		"target": "jsonl://%[1]s/scores.jsonl",
+		"dedup": {"keys": ["uid"], "scope": "run", "spill_after": 1},
+		"size": 2,
+		"workers": 4
+	}, {
+		"id": "scores_max",
+		"source": "csv://%[1]s/scores.csv",
+		"mapping": {"id": "uid", "score": "score"},
+		"target": "jsonl://%[1]s/scores_max.jsonl",
+		"dedup": {"keys": ["uid"], "scope": "run", "winner": "max", "column": "score"},
+		"size": 2,
+		"workers": 1
+	}]`)
+
+	// the earliest page wins across pages, whichever worker reads it first
+	for run := 0; run < 5; run++ {
+		os.Remove(dir + "/scores.jsonl")
+		if _, e := sy.DoSync("scores"); e != nil {
+			t.Fatal(e)
+		}
+
+		target, _ := ds.Connect("jsonl://" + dir + "/scores.jsonl")
+		res, _ := target.List(ds.ListOption{Orders: []ds.ListOrder{{Field: "uid", Order: "asc"}}})
+		scores := make([]string, len(res.Data))
+		for i, data := range res.Data {
+			row := data.(map[string]any)
+			scores[i] = fmt.Sprintf("%v:%v", row["uid"], row["score"])
+		}
+		if fmt.Sprint(scores) != "[1:5 2:1 3:4]" {
+			t.Fatalf("run %d: unexpected rows: %v", run, scores)
+		}
+	}
+
+	// the max score of uid 2 is in the last page, which the run scope can not pick
+	if _, e := sy.DoSync("scores_max"); e == nil || !strings.Contains(e.Error(), "can not be used with the run scope") {
+		t.Fatalf("expected dedup winner error, got %v", e)
+	}
+}
@@ -0,0 +1,89 @@
+package syncer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+const (
+	DialectMySQL    = "mysql"
+	DialectPostgres = "postgres"
+	DialectSQLite   = "sqlite"
+)
+
+func dialectOf(tx *gorm.DB) string {
+	if tx == nil || tx.Dialector == nil {
+		return ""
+	}
+
+	return tx.Dialector.Name()
+}
+
+// requiresConflictTarget reports whether the dialect upserts with
+// ON CONFLICT (cols), which needs an explicit conflict target
+func requiresConflictTarget(dialect string) bool {
+	return dialect == DialectPostgres || dialect == DialectSQLite
+}
+
+func quoteIdent(tx *gorm.DB, name string) string {
+	return tx.Statement.Quote(name)
+}
+
+// pgUniqueIndexes lists the column sets of the non-partial unique indexes
+// (including primary keys and unique constraints) on a postgres table
+func pgUniqueIndexes(tx *gorm.DB, table string) ([][]string, error) {
+	var rows []struct {
+		Cols string
+	}
+
+	e := tx.Raw(`SELECT string_agg(a.attname, ',') AS cols
+FROM pg_index ix
+JOIN pg_attribute a ON a.attrelid = ix.indrelid AND a.attnum = ANY(ix.indkey)
+WHERE ix.indrelid = to_regclass(?) AND ix.indisunique AND ix.indpred IS NULL
+GROUP BY ix.indexrelid`, table).Scan(&rows).Error
+
+	if e != nil {
+		return nil, e
+	}
+
+	indexes := make([][]string, 0, len(rows))
+	for _, row := range rows {
+		indexes = append(indexes, strings.Split(row.Cols, ","))
+	}
+
+	return indexes, nil
+}
+
+// checkConflictTarget fails when postgres has no unique index matching
+// the upsert columns exactly, since ON CONFLICT would be rejected
+func checkConflictTarget(tx *gorm.DB, table string, columns []string) error {
+	if dialectOf(tx) != DialectPostgres || len(columns) == 0 {
+		return nil
+	}
+
+	indexes, e := pgUniqueIndexes(tx, table)
+	if e != nil {
+		return e
+	}
+
+	want := sortedCopy(columns)
+	for _, index := range indexes {
+		if strings.Join(sortedCopy(index), ",") == strings.Join(want, ",") {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("[target] postgres upsert on %s requires a unique index or constraint on (%s), e.g. CREATE UNIQUE INDEX ON %s (%s)",
+		table, strings.Join(columns, ", "), table, strings.Join(columns, ", "))
+}
+
+func sortedCopy(items []string) []string {
+	c := make([]string, len(items))
+	copy(c, items)
+	sort.Strings(c)
+
+	return c
+}
@@ -0,0 +1,189 @@
+package syncer
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/enorith/syncer/ds"
+)
+
+const (
+	DriftPolicyFail   = "fail"
+	DriftPolicyWarn   = "warn"
+	DriftPolicyIgnore = "ignore"
+
+	DriftSourceMissing = "source_missing"
+	DriftTargetMissing = "target_missing"
+	DriftTypeMismatch  = "type_mismatch"
+)
+
+// SchemaTarget is implemented by targets which can describe the columns they write to,
+// nil columns skip the target side of the drift check
+type SchemaTarget interface {
+	TargetSchema(conf TargetConfig) ([]ds.Column, error)
+}
+
+// Drift a mapping field which no longer matches the source or the target schema
+type Drift struct {
+	Kind   string
+	Field  string
+	Detail string
+}
+
+func (d Drift) String() string {
+	return fmt.Sprintf("%s %s: %s", d.Kind, d.Field, d.Detail)
+}
+
+type DriftError struct {
+	TaskID string
+	Drifts []Drift
+}
+
+func (e *DriftError) Error() string {
+	drifts := make([]string, len(e.Drifts))
+	for i, drift := range e.Drifts {
+		drifts[i] = drift.String()
+	}
+
+	return fmt.Sprintf("[syncer] schema drift in task %s: %s", e.TaskID, strings.Join(drifts, "; "))
+}
+
+// Validate checks the mapping of a task against the source and target schemas
+func (s *Syncer) Validate(id string) ([]Drift, error) {
+	task, ok := s.GetTask(id)
+	if !ok {
+		return nil, fmt.Errorf("[syncer] task not found: %s", id)
+	}
+
+	dataSource, e := ds.Connect(task.Source)
+	if e != nil {
+		return nil, e
+	}
+
+	schema, e := sourceSchema(dataSource)
+	if e != nil {
+		return nil, e
+	}
+
+	var drifts []Drift
+	for _, tt := range task.taskTargets() {
+		target, e := ResolveTarget(tt.Target)
+		if e != nil {
+			return nil, e
+		}
+
+		found, e := detectDrift(task, tt, ParseMapping(tt.Mapping), schema, target)
+		if e != nil {
+			return nil, e
+		}
+		drifts = append(drifts, found...)
+	}
+
+	return drifts, nil
+}
+
+// checkDrift applies the drift policy of the task, the default policy is warn
+func checkDrift(task SyncerTask, tt TaskTarget, mapping Mapping, schema []ds.Column, target Target) error {
+	switch task.DriftPolicy {
+	case "", DriftPolicyWarn, DriftPolicyFail:
+	case DriftPolicyIgnore:
+		return nil
+	default:
+		return fmt.Errorf("[syncer] unknown drift policy: %s", task.DriftPolicy)
+	}
+
+	drifts, e := detectDrift(task, tt, mapping, schema, target)
+	if e != nil || len(drifts) == 0 {
+		return e
+	}
+
+	if task.DriftPolicy == DriftPolicyFail {
+		return &DriftError{TaskID: task.ID, Drifts: drifts}
+	}
+
+	for _, drift := range drifts {
+		log.Printf("[syncer] schema drift in task %s: %s", task.ID, drift)
+	}
+
+	return nil
+}
+
+func detectDrift(task SyncerTask, tt TaskTarget, mapping Mapping, schema []ds.Column, target Target) ([]Drift, error) {
+	var drifts []Drift
+
+	if schema != nil {
+		sources := columnsByName(schema)
+		for _, step := range mapping {
+			if _, ok := sources[strings.ToLower(step.Source)]; !ok && !step.Chained {
+				drifts = append(drifts, Drift{Kind: DriftSourceMissing, Field: step.Source, Detail: "not found in source " + task.Source})
+			}
+		}
+	}
+
+	schemaTarget, ok := target.(SchemaTarget)
+	if !ok {
+		return drifts, nil
+	}
+
+	targetColumns, e := schemaTarget.TargetSchema(tt.TargetConfig)
+	if e != nil || targetColumns == nil {
+		return drifts, e
+	}
+
+	// aggregated rows hold the groups and aggregates, not the mapped columns
+	written := mapping.Columns(schema)
+	if task.Aggregate != nil {
+		written = (&aggregatePlan{conf: *task.Aggregate}).targetColumns(written)
+	}
+
+	columns := columnsByName(targetColumns)
+	for _, mapped := range written {
+		column, ok := columns[strings.ToLower(mapped.Name)]
+		if !ok {
+			drifts = append(drifts, Drift{Kind: DriftTargetMissing, Field: mapped.Name, Detail: "not found in target " + tt.Target})
+			continue
+		}
+
+		if !typeCompatible(mapped.Type, column.Type) {
+			drifts = append(drifts, Drift{Kind: DriftTypeMismatch, Field: mapped.Name,
+				Detail: fmt.Sprintf("%s is written to %s column %s", mapped.Type, column.DatabaseType, column.Name)})
+		}
+	}
+
+	return drifts, nil
+}
+
+// typeCompatible whether values of a mapped type can be stored in a target column type,
+// unknown types always are
+func typeCompatible(from, to string) bool {
+	if from == "" || to == "" || from == to || to == ds.TypeString {
+		return true
+	}
+
+	switch from {
+	case ds.TypeInt:
+		return to == ds.TypeFloat || to == ds.TypeDecimal || to == ds.TypeBool
+	case ds.TypeFloat:
+		return to == ds.TypeDecimal
+	case ds.TypeDecimal:
+		return to == ds.TypeFloat
+	case ds.TypeBool:
+		return to == ds.TypeInt
+	case ds.TypeDate:
+		return to == ds.TypeTime
+	case ds.TypeString:
+		return to == ds.TypeTime || to == ds.TypeDate || to == ds.TypeTimeOfDay
+	}
+
+	return false
+}
+
+func columnsByName(columns []ds.Column) map[string]ds.Column {
+	byName := make(map[string]ds.Column, len(columns))
+	for _, column := range columns {
+		byName[strings.ToLower(column.Name)] = column
+	}
+
+	return byName
+}
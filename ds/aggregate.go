@@ -0,0 +1,226 @@
+package ds
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	AggSum           = "sum"
+	AggCount         = "count"
+	AggMin           = "min"
+	AggMax           = "max"
+	AggCountDistinct = "count_distinct"
+)
+
+// Aggregate an aggregate of ListOption, the value is named As, or func_field by default
+type Aggregate struct {
+	Func  string `json:"func"`
+	Field string `json:"field"`
+	As    string `json:"as"`
+}
+
+func (a Aggregate) Name() string {
+	if a.As != "" {
+		return a.As
+	}
+
+	if a.Field == "" {
+		return a.Func
+	}
+
+	return a.Func + "_" + a.Field
+}
+
+// Expr the sql expression of the aggregate
+func (a Aggregate) Expr() string {
+	field := a.Field
+	if field == "" {
+		field = "*"
+	}
+
+	if a.Func == AggCountDistinct {
+		return fmt.Sprintf("COUNT(DISTINCT %s)", field)
+	}
+
+	return fmt.Sprintf("%s(%s)", strings.ToUpper(a.Func), field)
+}
+
+// Aggregator is implemented by datasources which evaluate GroupBy and Aggregates of ListOption,
+// rows of other datasources can be aggregated in memory with Aggregation
+type Aggregator interface {
+	CanAggregate() bool
+}
+
+func CheckAggregates(aggregates []Aggregate) error {
+	for _, a := range aggregates {
+		switch a.Func {
+		case AggCount:
+		case AggSum, AggMin, AggMax, AggCountDistinct:
+			if a.Field == "" {
+				return fmt.Errorf("[datasource] aggregate %s requires field", a.Func)
+			}
+		default:
+			return fmt.Errorf("[datasource] unknown aggregate: %s", a.Func)
+		}
+	}
+
+	return nil
+}
+
+type aggState struct {
+	count    int64
+	sum      float64
+	intSum   int64
+	floats   bool
+	value    any
+	distinct map[string]struct{}
+}
+
+type aggGroup struct {
+	keys   map[string]any
+	states []*aggState
+}
+
+// Aggregation groups rows in memory, rows can be added across pages, nil values are skipped
+// like sql aggregates do and sums of integers stay integers
+type Aggregation struct {
+	groupBy    []string
+	aggregates []Aggregate
+
+	mu     sync.Mutex
+	groups map[string]*aggGroup
+	order  []string
+}
+
+func (a *Aggregation) Add(rows ...map[string]any) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, row := range rows {
+		k := rowKey(row, a.groupBy)
+		group, ok := a.groups[k]
+		if !ok {
+			group = &aggGroup{keys: make(map[string]any, len(a.groupBy)), states: make([]*aggState, len(a.aggregates))}
+			for _, field := range a.groupBy {
+				group.keys[field] = row[field]
+			}
+			for i := range group.states {
+				group.states[i] = &aggState{distinct: make(map[string]struct{})}
+			}
+			a.groups[k] = group
+			a.order = append(a.order, k)
+		}
+
+		for i, agg := range a.aggregates {
+			addAggregate(group.states[i], agg, row)
+		}
+	}
+}
+
+func addAggregate(state *aggState, agg Aggregate, row map[string]any) {
+	if agg.Field == "" {
+		state.count++
+		return
+	}
+
+	value := row[agg.Field]
+	if value == nil {
+		return
+	}
+
+	switch agg.Func {
+	case AggSum:
+		// values which are not numbers are skipped
+		f, ok := toFloat(value)
+		if !ok {
+			return
+		}
+		state.sum += f
+		if i, ok := intValue(value); ok && !state.floats {
+			state.intSum += i
+		} else {
+			state.floats = true
+		}
+	case AggMin:
+		if state.value == nil || CompareValues(value, state.value) < 0 {
+			state.value = value
+		}
+	case AggMax:
+		if state.value == nil || CompareValues(value, state.value) > 0 {
+			state.value = value
+		}
+	case AggCountDistinct:
+		state.distinct[ValueString(value)] = struct{}{}
+	}
+	state.count++
+}
+
+// Rows the groups in the order they were first seen
+func (a *Aggregation) Rows() []map[string]any {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	rows := make([]map[string]any, 0, len(a.order))
+	for _, k := range a.order {
+		group := a.groups[k]
+		row := make(map[string]any, len(a.groupBy)+len(a.aggregates))
+		for field, value := range group.keys {
+			row[field] = value
+		}
+
+		for i, agg := range a.aggregates {
+			state := group.states[i]
+			switch agg.Func {
+			case AggCount:
+				row[agg.Name()] = state.count
+			case AggCountDistinct:
+				row[agg.Name()] = int64(len(state.distinct))
+			case AggSum:
+				switch {
+				case state.count == 0:
+					row[agg.Name()] = nil
+				case state.floats:
+					row[agg.Name()] = state.sum
+				default:
+					row[agg.Name()] = state.intSum
+				}
+			default:
+				row[agg.Name()] = state.value
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows
+}
+
+func NewAggregation(groupBy []string, aggregates []Aggregate) *Aggregation {
+	return &Aggregation{groupBy: groupBy, aggregates: aggregates, groups: make(map[string]*aggGroup)}
+}
+
+// intValue integers, and strings of them as files read them
+func intValue(v any) (int64, bool) {
+	switch val := v.(type) {
+	case string:
+		i, e := strconv.ParseInt(strings.TrimSpace(val), 10, 64)
+		return i, e == nil
+	case []byte:
+		i, e := strconv.ParseInt(strings.TrimSpace(string(val)), 10, 64)
+		return i, e == nil
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return int64(rv.Uint()), true
+	}
+
+	return 0, false
+}
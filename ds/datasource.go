@@ -1,11 +1,18 @@
 package ds
 
 import (
+	"errors"
 	"fmt"
 	"net/url"
 	"sync"
 )
 
+var (
+	ErrNotSupported = errors.New("[datasource] operation not supported")
+
+	DefaultTimeFormat = "2006-01-02 15:04:05"
+)
+
 type ListMeta struct {
 	Total int64 `json:"total"`
 }
@@ -29,6 +36,14 @@ type ListOption struct {
 	Selects []string
 	Filters []ListFilter
 	Orders  []ListOrder
+
+	// After keyset pagination, lists the rows after these primary key values in primary key order
+	// instead of Page, for datasources implementing KeyProvider
+	After map[string]any
+
+	// GroupBy and Aggregates list one row per group, for datasources implementing Aggregator
+	GroupBy    []string
+	Aggregates []Aggregate
 }
 
 type ListResult struct {
@@ -48,6 +63,22 @@ type Datasource interface {
 	ListMeta(filters ...ListFilter) (ListMeta, error)
 }
 
+// Upserter is implemented by datasources which can insert or update rows by key in bulk
+type Upserter interface {
+	Upsert(data any, keys ...string) error
+}
+
+// KeyProvider is implemented by datasources which know the primary keys of their rows
+type KeyProvider interface {
+	PrimaryKeys() []string
+}
+
+// Sequential is implemented by datasources whose pages are reached one after another, such as cursor
+// paginated apis, readers list them in page order from the first page without counting them first
+type Sequential interface {
+	Sequential() bool
+}
+
 type Register func(u *url.URL) (Datasource, error)
 
 var (
@@ -2,14 +2,19 @@ package ds
 
 import (
 	"errors"
+	"fmt"
 	"net/url"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
 
 	"github.com/enorith/gormdb"
 	"github.com/enorith/supports/collection"
+	"github.com/enorith/supports/dbutil"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
 )
 
 type DBListModel interface {
@@ -18,9 +23,10 @@ type DBListModel interface {
 }
 
 type DB struct {
-	tx        *gorm.DB
-	table, pk string
-	model     any
+	tx    *gorm.DB
+	table string
+	pks   []string
+	model any
 }
 
 func (db *DB) List(opt ListOption) (ListResult, error) {
@@ -38,6 +44,10 @@ func (db *DB) List(opt ListOption) (ListResult, error) {
 			d = d.Select(opt.Selects)
 		}
 
+		if opt.After != nil {
+			d = d.Where(keysetCondition(db.pks, opt.After))
+		}
+
 		return d
 	})
 
@@ -45,6 +55,14 @@ func (db *DB) List(opt ListOption) (ListResult, error) {
 		tx = tx.Scopes(m.ListScope())
 	}
 
+	aggregated := len(opt.GroupBy) > 0 || len(opt.Aggregates) > 0
+	if aggregated {
+		if e := CheckAggregates(opt.Aggregates); e != nil {
+			return ListResult{}, e
+		}
+		tx = tx.Scopes(db.aggregateScope(opt))
+	}
+
 	result := ListResult{
 		Data: make([]any, 0),
 		Meta: ListMeta{
@@ -54,7 +72,8 @@ func (db *DB) List(opt ListOption) (ListResult, error) {
 
 	if !opt.WithoutMeta {
 		aggTable := tx.Session(&gorm.Session{})
-		if isModel {
+		// grouped lists count their groups
+		if isModel && !aggregated {
 			aggTable = aggTable.Scopes(m.AggTableScope())
 		}
 
@@ -64,6 +83,12 @@ func (db *DB) List(opt ListOption) (ListResult, error) {
 		}
 	}
 
+	if opt.After != nil && len(opt.Orders) == 0 {
+		opt.Orders = collection.Map(db.pks, func(pk string) ListOrder {
+			return ListOrder{Field: pk, Order: "asc"}
+		})
+	}
+
 	if len(opt.Orders) > 0 {
 		orders := collection.Map(opt.Orders, func(order ListOrder) string {
 			return order.Field + " " + order.Order
@@ -75,13 +100,13 @@ func (db *DB) List(opt ListOption) (ListResult, error) {
 		tx = tx.Limit(int(opt.Limit))
 	}
 
-	if opt.Page < 1 {
+	if opt.Page < 1 || opt.After != nil {
 		opt.Page = 1
 	}
 
 	tx = tx.Offset((int((opt.Page - 1) * opt.Limit)))
 	var e error
-	if _, ok := db.model.(MapModel); ok {
+	if _, ok := db.model.(MapModel); ok || aggregated {
 		var sv []map[string]any
 
 		e = tx.Find(&sv).Error
@@ -134,10 +159,24 @@ func (db *DB) ListMeta(filters ...ListFilter) (ListMeta, error) {
 	return meta, e
 }
 
+// Find finds a row by its key, a value of a single primary key, or a map, struct or list in key order
+// of composite primary keys
 func (db *DB) Find(id any) (any, error) {
+	scope, e := db.keyScope(id)
+	if e != nil {
+		return nil, e
+	}
+
+	if _, ok := db.model.(MapModel); ok {
+		row := make(map[string]any)
+		e := db.newSession().Table(db.table).Scopes(scope).Limit(1).Find(&row).Error
+
+		return row, e
+	}
+
 	model := db.newModel()
 
-	e := db.newSession().Table(db.table).Find(model, db.pk+" = ?", id).Error
+	e = db.newSession().Table(db.table).Scopes(scope).Find(model).Error
 
 	return model, e
 }
@@ -146,8 +185,52 @@ func (db *DB) Create(data any) error {
 	return db.newSession().Table(db.table).Create(data).Error
 }
 
+// Upsert creates rows, updating the other columns of rows which conflict on keys
+func (db *DB) Upsert(data any, keys ...string) error {
+	var opts []dbutil.UpsertOpt
+	if len(keys) > 0 {
+		opts = append(opts, dbutil.UpsertOptColumns(keys...))
+	}
+
+	tx := db.newSession().Table(db.table)
+
+	if rows, ok := data.([]map[string]any); ok {
+		if len(rows) == 0 {
+			return nil
+		}
+
+		var updates []string
+		for column := range rows[0] {
+			if !collection.Contains(keys, column) {
+				updates = append(updates, column)
+			}
+		}
+		sort.Strings(updates)
+
+		if len(updates) > 0 {
+			opts = append(opts, dbutil.UpsertOptUpdateColumns(updates...))
+		} else {
+			opts = append(opts, func(on clause.OnConflict) clause.OnConflict {
+				on.DoNothing = true
+				return on
+			})
+		}
+
+		return tx.Scopes(dbutil.WithUpsert(opts...)).Create(rows).Error
+	}
+
+	return tx.Clauses(clause.OnConflict{Columns: collection.Map(keys, func(key string) clause.Column {
+		return clause.Column{Name: key}
+	}), UpdateAll: true}).Create(data).Error
+}
+
 func (db *DB) Update(id any, data any) error {
-	return db.newSession().Table(db.table).Where(db.pk+" = ?", id).Updates(data).Error
+	scope, e := db.keyScope(id)
+	if e != nil {
+		return e
+	}
+
+	return db.newSession().Table(db.table).Scopes(scope).Updates(data).Error
 }
 
 func (db *DB) UpdateMany(data any, filters ...ListFilter) error {
@@ -159,8 +242,13 @@ func (db *DB) UpdateMany(data any, filters ...ListFilter) error {
 }
 
 func (db *DB) Delete(id any) error {
+	scope, e := db.keyScope(id)
+	if e != nil {
+		return e
+	}
+
 	model := db.newModel()
-	return db.newSession().Table(db.table).Where(db.pk+" = ?", id).Delete(model).Error
+	return db.newSession().Table(db.table).Scopes(scope).Delete(model).Error
 }
 
 func (db *DB) DeleteMany(filters ...ListFilter) error {
@@ -174,6 +262,144 @@ func (db *DB) DeleteMany(filters ...ListFilter) error {
 	return tx.Delete(model).Error
 }
 
+// Schema columns of the table as reported by the gorm migrator
+func (db *DB) Schema() ([]Column, error) {
+	types, e := db.newSession().Migrator().ColumnTypes(db.table)
+	if e != nil {
+		return nil, e
+	}
+
+	return collection.Map(types, func(ct gorm.ColumnType) Column {
+		column := Column{Name: ct.Name(), DatabaseType: ct.DatabaseTypeName(), Nullable: true}
+		column.Type = NormalizeType(column.DatabaseType)
+		if length, ok := ct.Length(); ok {
+			column.Length = length
+		}
+		if precision, scale, ok := ct.DecimalSize(); ok && column.Type == TypeDecimal {
+			column.Precision, column.Scale = precision, scale
+		}
+		if nullable, ok := ct.Nullable(); ok {
+			column.Nullable = nullable
+		}
+		if pk, ok := ct.PrimaryKey(); ok {
+			column.PrimaryKey = pk
+		}
+
+		return column
+	}), nil
+}
+
+// aggregateScope selects the group columns and aggregates of opt, grouped by the group columns
+func (db *DB) aggregateScope(opt ListOption) func(*gorm.DB) *gorm.DB {
+	return func(d *gorm.DB) *gorm.DB {
+		selects := append([]string{}, opt.GroupBy...)
+		for _, a := range opt.Aggregates {
+			selects = append(selects, a.Expr()+" AS "+a.Name())
+		}
+		d = d.Select(strings.Join(selects, ", "))
+
+		if len(opt.GroupBy) > 0 {
+			d = d.Group(strings.Join(opt.GroupBy, ", "))
+		}
+
+		return d
+	}
+}
+
+// CanAggregate groups and aggregates of ListOption are evaluated in sql
+func (db *DB) CanAggregate() bool {
+	return true
+}
+
+// PrimaryKeys the primary key columns of the table
+func (db *DB) PrimaryKeys() []string {
+	return db.pks
+}
+
+// keyScope filters the row of a key
+func (db *DB) keyScope(id any) (func(*gorm.DB) *gorm.DB, error) {
+	values, e := db.keyValues(id)
+	if e != nil {
+		return nil, e
+	}
+
+	return func(d *gorm.DB) *gorm.DB {
+		for _, pk := range db.pks {
+			d = d.Where(pk+" = ?", values[pk])
+		}
+
+		return d
+	}, nil
+}
+
+// keyValues the primary key values of a key, fields of a struct key are matched by their
+// gorm column, json name or the column gorm names them
+func (db *DB) keyValues(id any) (map[string]any, error) {
+	values := make(map[string]any, len(db.pks))
+
+	switch key := id.(type) {
+	case map[string]any:
+		values = key
+	case []any:
+		if len(key) != len(db.pks) {
+			return nil, fmt.Errorf("[datasource] key of %d values, primary keys are %s", len(key), strings.Join(db.pks, ","))
+		}
+		for i, pk := range db.pks {
+			values[pk] = key[i]
+		}
+	default:
+		rv := reflect.Indirect(reflect.ValueOf(id))
+		if rv.Kind() != reflect.Struct {
+			if len(db.pks) > 1 {
+				return nil, fmt.Errorf("[datasource] composite primary keys %s require a map, struct or list key", strings.Join(db.pks, ","))
+			}
+			values[db.pks[0]] = id
+			break
+		}
+
+		for i := 0; i < rv.NumField(); i++ {
+			if field := rv.Type().Field(i); field.IsExported() {
+				values[db.fieldColumn(field)] = rv.Field(i).Interface()
+			}
+		}
+	}
+
+	for _, pk := range db.pks {
+		if _, ok := values[pk]; !ok {
+			return nil, fmt.Errorf("[datasource] key requires primary key %s", pk)
+		}
+	}
+
+	return values, nil
+}
+
+func (db *DB) fieldColumn(field reflect.StructField) string {
+	if column := schema.ParseTagSetting(field.Tag.Get("gorm"), ";")["COLUMN"]; column != "" {
+		return column
+	}
+
+	if name, _, _ := strings.Cut(field.Tag.Get("json"), ","); name != "" && name != "-" {
+		return name
+	}
+
+	return db.tx.NamingStrategy.ColumnName("", field.Name)
+}
+
+// keysetCondition rows after the values of keys in key order, (a > ?) OR (a = ? AND b > ?)...
+func keysetCondition(keys []string, after map[string]any) clause.Expression {
+	var ors []clause.Expression
+	for i, key := range keys {
+		var ands []clause.Expression
+		for _, prev := range keys[:i] {
+			ands = append(ands, clause.Eq{Column: clause.Column{Name: prev}, Value: after[prev]})
+		}
+		ands = append(ands, clause.Gt{Column: clause.Column{Name: key}, Value: after[key]})
+		ors = append(ors, clause.And(ands...))
+	}
+
+	return clause.Or(ors...)
+}
+
 func (db *DB) applyFilter(tx *gorm.DB, filter ListFilter) *gorm.DB {
 	switch strings.ToLower(filter.Op) {
 	case "between":
@@ -207,8 +433,24 @@ type NewDBConfig struct {
 	Model     any
 }
 
+// NewDB PK is a primary key column or comma separated composite primary key columns, id by default
 func NewDB(tx *gorm.DB, conf NewDBConfig) *DB {
-	return &DB{tx: tx, table: conf.Table, pk: conf.PK, model: conf.Model}
+	return &DB{tx: tx, table: conf.Table, pks: splitKeys(conf.PK), model: conf.Model}
+}
+
+func splitKeys(pk string) []string {
+	var keys []string
+	for _, key := range strings.Split(pk, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+
+	if len(keys) == 0 {
+		return []string{"id"}
+	}
+
+	return keys
 }
 
 var (
@@ -255,13 +497,7 @@ func DBRegister(url *url.URL) (Datasource, error) {
 		model = MapModel(table)
 	}
 
-	pk := url.Query().Get("pk")
-
-	if pk == "" {
-		pk = "id"
-	}
-
-	return &DB{tx: db, table: table, pk: pk, model: model}, nil
+	return NewDB(db, NewDBConfig{Table: table, PK: url.Query().Get("pk"), Model: model}), nil
 }
 
 type MapModel string
@@ -1,10 +1,13 @@
 package ds_test
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -12,6 +15,7 @@ import (
 	"github.com/enorith/syncer/ds"
 	"github.com/joho/godotenv"
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
@@ -60,6 +64,44 @@ func getDB() (*gorm.DB, error) {
 	})
 }
 
+type sqlRecorder struct {
+	logger.Interface
+	mu   sync.Mutex
+	sqls []string
+}
+
+func (r *sqlRecorder) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	sql, _ := fc()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sqls = append(r.sqls, sql)
+}
+
+func (r *sqlRecorder) LogMode(logger.LogLevel) logger.Interface {
+	return r
+}
+
+// dryRunDB a dry run session of a mysql or postgres dialect recording its statements, queries return no rows
+func dryRunDB(t *testing.T, dialect string) (*gorm.DB, *sqlRecorder) {
+	dialector := postgres.New(postgres.Config{DSN: "host=localhost"})
+	if dialect == "mysql" {
+		dialector = mysql.New(mysql.Config{DSN: "root@tcp(localhost)/test", SkipInitializeWithVersion: true})
+	}
+
+	recorder := &sqlRecorder{Interface: logger.Discard}
+	db, e := gorm.Open(dialector, &gorm.Config{
+		DryRun:                 true,
+		SkipDefaultTransaction: true,
+		DisableAutomaticPing:   true,
+		Logger:                 recorder,
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	return db, recorder
+}
+
 func TestDbSource(t *testing.T) {
 	loadEnv()
 
@@ -117,3 +159,84 @@ func TestDSN(t *testing.T) {
 	}
 	printJson(res)
 }
+
+func TestDBAggregateSQL(t *testing.T) {
+	gormDB, recorder := dryRunDB(t, "postgres")
+	db := ds.NewDB(gormDB, ds.NewDBConfig{Table: "orders", Model: ds.MapModel("orders")})
+
+	_, e := db.List(ds.ListOption{
+		Filters: []ds.ListFilter{{Field: "status", Op: "=", Value: "paid"}},
+		GroupBy: []string{"shop_id", "day"},
+		Aggregates: []ds.Aggregate{
+			{Func: ds.AggSum, Field: "amount", As: "total"},
+			{Func: ds.AggCount},
+			{Func: ds.AggCountDistinct, Field: "customer_id", As: "customers"},
+		},
+		Orders: []ds.ListOrder{{Field: "shop_id", Order: "asc"}},
+		Limit:  10,
+		Page:   2,
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	want := []string{
+		// the groups are counted, source filters apply to rows before grouping
+		`SELECT count(*) FROM (SELECT shop_id, day, SUM(amount) AS total, COUNT(*) AS count, COUNT(DISTINCT customer_id) AS customers FROM "orders" WHERE status = 'paid' GROUP BY shop_id, day) aggragate`,
+		`SELECT shop_id, day, SUM(amount) AS total, COUNT(*) AS count, COUNT(DISTINCT customer_id) AS customers FROM "orders" WHERE status = 'paid' GROUP BY shop_id, day ORDER BY shop_id asc LIMIT 10 OFFSET 10`,
+	}
+	if strings.Join(recorder.sqls, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected sql:\n%s", strings.Join(recorder.sqls, "\n"))
+	}
+
+	if _, e := db.List(ds.ListOption{Aggregates: []ds.Aggregate{{Func: "median", Field: "amount"}}}); e == nil {
+		t.Fatal("expected error of an unknown aggregate")
+	}
+}
+
+func TestDBCompositeKeysSQL(t *testing.T) {
+	for dialect, want := range map[string][]string{
+		"postgres": {
+			`SELECT * FROM "order_items" WHERE order_id = 7 AND line = 2 LIMIT 1`,
+			`UPDATE "order_items" SET "qty"=3 WHERE order_id = 7 AND line = 2`,
+			`DELETE FROM "order_items" WHERE order_id = 7 AND line = 2`,
+			`SELECT * FROM "order_items" WHERE ("order_id" > 7 OR ("order_id" = 7 AND "line" > 2)) ORDER BY order_id asc, line asc LIMIT 2`,
+		},
+		"mysql": {
+			"SELECT * FROM `order_items` WHERE order_id = 7 AND line = 2 LIMIT 1",
+			"UPDATE `order_items` SET `qty`=3 WHERE order_id = 7 AND line = 2",
+			"DELETE FROM `order_items` WHERE order_id = 7 AND line = 2",
+			"SELECT * FROM `order_items` WHERE (`order_id` > 7 OR (`order_id` = 7 AND `line` > 2)) ORDER BY order_id asc, line asc LIMIT 2",
+		},
+	} {
+		gormDB, recorder := dryRunDB(t, dialect)
+		db := ds.NewDB(gormDB, ds.NewDBConfig{Table: "order_items", PK: "order_id, line", Model: ds.MapModel("order_items")})
+
+		if _, e := db.Find(map[string]any{"order_id": 7, "line": 2}); e != nil {
+			t.Fatal(e)
+		}
+		if e := db.Update([]any{7, 2}, map[string]any{"qty": 3}); e != nil {
+			t.Fatal(e)
+		}
+		if e := db.Delete(struct {
+			OrderID int64
+			Line    int `json:"line"`
+		}{7, 2}); e != nil {
+			t.Fatal(e)
+		}
+		if _, e := db.List(ds.ListOption{After: map[string]any{"order_id": 7, "line": 2}, Limit: 2, WithoutMeta: true}); e != nil {
+			t.Fatal(e)
+		}
+
+		if strings.Join(recorder.sqls, "\n") != strings.Join(want, "\n") {
+			t.Fatalf("%s: unexpected sql:\n%s", dialect, strings.Join(recorder.sqls, "\n"))
+		}
+
+		if _, e := db.Find(7); e == nil || !strings.Contains(e.Error(), "composite primary keys") {
+			t.Fatalf("%s: expected composite key error, got %v", dialect, e)
+		}
+		if e := db.Update([]any{7}, map[string]any{"qty": 3}); e == nil {
+			t.Fatalf("%s: expected error of a partial key", dialect)
+		}
+	}
+}
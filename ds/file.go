@@ -0,0 +1,766 @@
+package ds
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	jsoniter "github.com/json-iterator/go"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/transform"
+)
+
+const (
+	FileFormatCSV   = "csv"
+	FileFormatJSONL = "jsonl"
+)
+
+var (
+	errStopEach = errors.New("stop")
+	jsonNumber  = jsoniter.Config{UseNumber: true}.Froze()
+	// decimalLiteral values inferred as numbers, codes with leading zeros, exponents, hex
+	// and NaN or Inf spellings stay strings
+	decimalLiteral = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?$`)
+)
+
+type FileConfig struct {
+	Path   string
+	Format string
+
+	// Header csv first line is the header, otherwise Columns (or col1, col2...) are used
+	Header    bool
+	Columns   []string
+	Delimiter rune
+	// Encoding any name known by the WHATWG encoding spec, such as gbk, gb18030 or utf-16le
+	Encoding string
+	Gzip     bool
+	// Infer converts plain decimal csv values to int64 or float64, true and false to bool, empty values to nil
+	Infer bool
+	PK    string
+}
+
+// File csv or json lines file datasource, filters and orders are evaluated in memory
+type File struct {
+	conf FileConfig
+	enc  encoding.Encoding
+	mu   sync.RWMutex
+}
+
+func (f *File) List(opt ListOption) (ListResult, error) {
+	result := ListResult{
+		Data: make([]any, 0),
+	}
+
+	if opt.Page < 1 {
+		opt.Page = 1
+	}
+	offset := (opt.Page - 1) * opt.Limit
+
+	if len(opt.Orders) > 0 {
+		var rows []map[string]any
+		e := f.each(func(row map[string]any) error {
+			if MatchFilters(row, opt.Filters...) {
+				rows = append(rows, row)
+			}
+			return nil
+		})
+		if e != nil {
+			return result, e
+		}
+
+		SortRows(rows, opt.Orders)
+		result.Meta.Total = int64(len(rows))
+
+		for i := offset; i < int64(len(rows)) && (opt.Limit <= 0 || i < offset+opt.Limit); i++ {
+			result.Data = append(result.Data, ProjectRow(rows[i], opt.Selects))
+		}
+
+		return result, nil
+	}
+
+	var matched int64
+	e := f.each(func(row map[string]any) error {
+		if !MatchFilters(row, opt.Filters...) {
+			return nil
+		}
+
+		if matched >= offset && (opt.Limit <= 0 || matched < offset+opt.Limit) {
+			result.Data = append(result.Data, ProjectRow(row, opt.Selects))
+		}
+		matched++
+
+		if opt.WithoutMeta && opt.Limit > 0 && matched >= offset+opt.Limit {
+			return errStopEach
+		}
+		return nil
+	})
+
+	if !opt.WithoutMeta {
+		result.Meta.Total = matched
+	}
+
+	return result, e
+}
+
+func (f *File) ListMeta(filters ...ListFilter) (ListMeta, error) {
+	var meta ListMeta
+
+	e := f.each(func(row map[string]any) error {
+		if MatchFilters(row, filters...) {
+			meta.Total++
+		}
+		return nil
+	})
+
+	return meta, e
+}
+
+// Schema columns of the header or Columns for csv, of every row for json lines, types are not known
+func (f *File) Schema() ([]Column, error) {
+	names := f.conf.Columns
+
+	switch {
+	case len(names) > 0:
+	case f.conf.Format == FileFormatJSONL:
+		seen := make(map[string]bool)
+		e := f.each(func(row map[string]any) error {
+			for name := range row {
+				if !seen[name] {
+					seen[name] = true
+					names = append(names, name)
+				}
+			}
+
+			return nil
+		})
+		if e != nil {
+			return nil, e
+		}
+		sort.Strings(names)
+	case f.conf.Header:
+		f.mu.RLock()
+		header, e := f.readHeader()
+		f.mu.RUnlock()
+		if e != nil {
+			return nil, e
+		}
+		names = header
+	default:
+		return nil, nil
+	}
+
+	columns := make([]Column, len(names))
+	for i, name := range names {
+		columns[i] = Column{Name: name, Nullable: true}
+	}
+
+	return columns, nil
+}
+
+func (f *File) Find(id any) (any, error) {
+	var found map[string]any
+
+	e := f.each(func(row map[string]any) error {
+		if CompareValues(row[f.conf.PK], id) == 0 {
+			found = row
+			return errStopEach
+		}
+		return nil
+	})
+
+	return found, e
+}
+
+// Create appends rows to the file, creating it (and the csv header) when missing
+func (f *File) Create(data any) error {
+	rows, e := toRows(data)
+	if e != nil || len(rows) == 0 {
+		return e
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info, e := os.Stat(f.conf.Path)
+	exists := e == nil && info.Size() > 0
+
+	columns := f.conf.Columns
+	if f.conf.Format == FileFormatCSV && exists && f.conf.Header {
+		columns, e = f.readHeader()
+		if e != nil {
+			return e
+		}
+	}
+
+	if len(columns) == 0 {
+		columns = rowColumns(nil, rows)
+	}
+
+	file, e := os.OpenFile(f.conf.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if e != nil {
+		return e
+	}
+
+	return f.write(file, columns, rows, !exists)
+}
+
+// Upsert updates rows matching keys in place and appends the others
+func (f *File) Upsert(data any, keys ...string) error {
+	rows, e := toRows(data)
+	if e != nil || len(rows) == 0 {
+		return e
+	}
+
+	if len(keys) == 0 {
+		keys = []string{f.conf.PK}
+	}
+
+	pending := make(map[string]map[string]any, len(rows))
+	order := make([]string, 0, len(rows))
+	for _, row := range rows {
+		key := rowKey(row, keys)
+		if _, ok := pending[key]; !ok {
+			order = append(order, key)
+		}
+		pending[key] = row
+	}
+
+	return f.rewrite(func(row map[string]any) map[string]any {
+		key := rowKey(row, keys)
+		if update, ok := pending[key]; ok {
+			for k, v := range update {
+				row[k] = v
+			}
+			delete(pending, key)
+		}
+		return row
+	}, func() []map[string]any {
+		var appended []map[string]any
+		for _, key := range order {
+			if row, ok := pending[key]; ok {
+				appended = append(appended, row)
+			}
+		}
+		return appended
+	})
+}
+
+func (f *File) Update(id any, data any) error {
+	return f.UpdateMany(data, ListFilter{Field: f.conf.PK, Op: "=", Value: id})
+}
+
+func (f *File) UpdateMany(data any, filters ...ListFilter) error {
+	updates, e := toRows(data)
+	if e != nil || len(updates) == 0 {
+		return e
+	}
+
+	return f.rewrite(func(row map[string]any) map[string]any {
+		if MatchFilters(row, filters...) {
+			for k, v := range updates[0] {
+				row[k] = v
+			}
+		}
+		return row
+	})
+}
+
+func (f *File) Delete(id any) error {
+	return f.DeleteMany(ListFilter{Field: f.conf.PK, Op: "=", Value: id})
+}
+
+func (f *File) DeleteMany(filters ...ListFilter) error {
+	return f.rewrite(func(row map[string]any) map[string]any {
+		if MatchFilters(row, filters...) {
+			return nil
+		}
+		return row
+	})
+}
+
+// rewrite applies fn to every row and atomically replaces the file, rows mapped to nil are dropped,
+// rows returned by appends are added at the end
+func (f *File) rewrite(fn func(row map[string]any) map[string]any, appends ...func() []map[string]any) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var (
+		rows    []map[string]any
+		columns []string
+	)
+
+	if f.conf.Format == FileFormatCSV && f.conf.Header {
+		columns, _ = f.readHeader()
+	}
+	if len(columns) == 0 {
+		columns = f.conf.Columns
+	}
+
+	e := f.eachUnlocked(func(row map[string]any) error {
+		if row = fn(row); row != nil {
+			rows = append(rows, row)
+		}
+		return nil
+	})
+	if e != nil && !os.IsNotExist(e) {
+		return e
+	}
+
+	for _, appended := range appends {
+		rows = append(rows, appended()...)
+	}
+
+	tmp, e := os.CreateTemp(filepath.Dir(f.conf.Path), "."+filepath.Base(f.conf.Path)+".*")
+	if e != nil {
+		return e
+	}
+
+	if e = f.write(tmp, rowColumns(columns, rows), rows, true); e != nil {
+		os.Remove(tmp.Name())
+		return e
+	}
+
+	return os.Rename(tmp.Name(), f.conf.Path)
+}
+
+func (f *File) each(fn func(row map[string]any) error) error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.eachUnlocked(fn)
+}
+
+func (f *File) eachUnlocked(fn func(row map[string]any) error) error {
+	r, closer, e := f.open()
+	if e != nil {
+		return e
+	}
+	defer closer()
+
+	if f.conf.Format == FileFormatCSV {
+		e = f.eachCSV(r, fn)
+	} else {
+		e = f.eachJSONL(r, fn)
+	}
+
+	if errors.Is(e, errStopEach) {
+		return nil
+	}
+
+	return e
+}
+
+func (f *File) eachCSV(r io.Reader, fn func(row map[string]any) error) error {
+	reader := f.csvReader(r)
+	columns := f.conf.Columns
+
+	if f.conf.Header {
+		header, e := reader.Read()
+		if e == io.EOF {
+			return nil
+		}
+		if e != nil {
+			return e
+		}
+		columns = trimHeader(header)
+	}
+
+	for {
+		record, e := reader.Read()
+		if e == io.EOF {
+			return nil
+		}
+		if e != nil {
+			return e
+		}
+
+		row := make(map[string]any, len(record))
+		for i, value := range record {
+			var column string
+			if i < len(columns) {
+				column = columns[i]
+			} else {
+				column = "col" + strconv.Itoa(i+1)
+			}
+
+			if f.conf.Infer {
+				row[column] = inferValue(value)
+			} else {
+				row[column] = value
+			}
+		}
+
+		if e := fn(row); e != nil {
+			return e
+		}
+	}
+}
+
+func (f *File) eachJSONL(r io.Reader, fn func(row map[string]any) error) error {
+	reader := bufio.NewReader(r)
+
+	for {
+		line, e := reader.ReadBytes('\n')
+		if e != nil && e != io.EOF {
+			return e
+		}
+
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			var row map[string]any
+			if err := jsonNumber.Unmarshal(line, &row); err != nil {
+				return err
+			}
+
+			for k, v := range row {
+				row[k] = numberValue(v)
+			}
+
+			if err := fn(row); err != nil {
+				return err
+			}
+		}
+
+		if e == io.EOF {
+			return nil
+		}
+	}
+}
+
+func (f *File) readHeader() ([]string, error) {
+	r, closer, e := f.open()
+	if e != nil {
+		return nil, e
+	}
+	defer closer()
+
+	header, e := f.csvReader(r).Read()
+	if e == io.EOF {
+		return nil, nil
+	}
+
+	return trimHeader(header), e
+}
+
+func (f *File) open() (io.Reader, func(), error) {
+	file, e := os.Open(f.conf.Path)
+	if e != nil {
+		return nil, nil, e
+	}
+
+	var r io.Reader = file
+	if f.conf.Gzip {
+		gz, e := gzip.NewReader(file)
+		if e != nil {
+			file.Close()
+			if e == io.EOF {
+				return bytes.NewReader(nil), func() {}, nil
+			}
+			return nil, nil, e
+		}
+		r = gz
+	}
+
+	if f.enc != nil {
+		r = f.enc.NewDecoder().Reader(r)
+	}
+
+	return r, func() { file.Close() }, nil
+}
+
+func (f *File) write(file *os.File, columns []string, rows []map[string]any, header bool) error {
+	var (
+		w       io.Writer = file
+		closers []io.Closer
+	)
+
+	if f.conf.Gzip {
+		gz := gzip.NewWriter(w)
+		closers = append(closers, gz)
+		w = gz
+	}
+
+	if f.enc != nil {
+		ew := transform.NewWriter(w, f.enc.NewEncoder())
+		closers = append(closers, ew)
+		w = ew
+	}
+
+	e := f.writeRows(w, columns, rows, header)
+
+	for i := len(closers) - 1; i >= 0; i-- {
+		if err := closers[i].Close(); err != nil && e == nil {
+			e = err
+		}
+	}
+
+	if err := file.Close(); err != nil && e == nil {
+		e = err
+	}
+
+	return e
+}
+
+func (f *File) writeRows(w io.Writer, columns []string, rows []map[string]any, header bool) error {
+	if f.conf.Format != FileFormatCSV {
+		for _, row := range rows {
+			b, e := jsoniter.Marshal(row)
+			if e != nil {
+				return e
+			}
+
+			if _, e := w.Write(append(b, '\n')); e != nil {
+				return e
+			}
+		}
+
+		return nil
+	}
+
+	writer := csv.NewWriter(w)
+	writer.Comma = f.conf.Delimiter
+
+	if header && f.conf.Header {
+		if e := writer.Write(columns); e != nil {
+			return e
+		}
+	}
+
+	record := make([]string, len(columns))
+	for _, row := range rows {
+		for i, column := range columns {
+			record[i] = ValueString(row[column])
+		}
+
+		if e := writer.Write(record); e != nil {
+			return e
+		}
+	}
+
+	writer.Flush()
+
+	return writer.Error()
+}
+
+func (f *File) csvReader(r io.Reader) *csv.Reader {
+	reader := csv.NewReader(r)
+	reader.Comma = f.conf.Delimiter
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.ReuseRecord = true
+
+	return reader
+}
+
+func NewFile(conf FileConfig) (*File, error) {
+	if conf.Path == "" {
+		return nil, errors.New("[datasource] file path is required")
+	}
+
+	name := strings.ToLower(conf.Path)
+	if strings.HasSuffix(name, ".gz") {
+		conf.Gzip = true
+		name = strings.TrimSuffix(name, ".gz")
+	}
+
+	if conf.Format == "" {
+		switch filepath.Ext(name) {
+		case ".csv", ".tsv", ".txt":
+			conf.Format = FileFormatCSV
+		case ".jsonl", ".ndjson", ".json":
+			conf.Format = FileFormatJSONL
+		default:
+			return nil, fmt.Errorf("[datasource] unknown file format: %s", conf.Path)
+		}
+	}
+
+	if conf.Format != FileFormatCSV && conf.Format != FileFormatJSONL {
+		return nil, fmt.Errorf("[datasource] unsupported file format: %s", conf.Format)
+	}
+
+	if conf.Delimiter == 0 {
+		conf.Delimiter = ','
+		if filepath.Ext(name) == ".tsv" {
+			conf.Delimiter = '\t'
+		}
+	}
+
+	if conf.PK == "" {
+		conf.PK = "id"
+	}
+
+	f := &File{conf: conf}
+
+	if conf.Encoding != "" && !strings.EqualFold(conf.Encoding, "utf-8") && !strings.EqualFold(conf.Encoding, "utf8") {
+		enc, e := htmlindex.Get(conf.Encoding)
+		if e != nil {
+			return nil, fmt.Errorf("[datasource] unsupported encoding: %s", conf.Encoding)
+		}
+		f.enc = enc
+	}
+
+	return f, nil
+}
+
+// FileRegister registers file datasources, such as:
+//
+//	csv:///data/users.csv?delimiter=semicolon&encoding=gbk
+//	jsonl://./dumps/users.jsonl.gz
+//	file:///data/users.tsv?header=false&columns=id,name
+func FileRegister(u *url.URL) (Datasource, error) {
+	query := u.Query()
+
+	path := u.Opaque
+	if path == "" {
+		path = u.Host + u.Path
+	}
+
+	conf := FileConfig{
+		Path:     path,
+		Header:   query.Get("header") != "false",
+		Encoding: query.Get("encoding"),
+		Gzip:     query.Get("gzip") == "true",
+		Infer:    query.Get("infer") != "false",
+		PK:       query.Get("pk"),
+		Format:   query.Get("format"),
+	}
+
+	switch u.Scheme {
+	case FileFormatCSV, FileFormatJSONL:
+		conf.Format = u.Scheme
+	}
+
+	if columns := query.Get("columns"); columns != "" {
+		conf.Columns = strings.Split(columns, ",")
+	}
+
+	switch delimiter := query.Get("delimiter"); delimiter {
+	case "":
+	case "tab", `\t`:
+		conf.Delimiter = '\t'
+	case "semicolon":
+		conf.Delimiter = ';'
+	case "pipe":
+		conf.Delimiter = '|'
+	default:
+		conf.Delimiter = []rune(delimiter)[0]
+	}
+
+	return NewFile(conf)
+}
+
+func trimHeader(header []string) []string {
+	columns := make([]string, len(header))
+	for i, column := range header {
+		columns[i] = strings.TrimSpace(strings.TrimPrefix(column, "\ufeff"))
+	}
+
+	return columns
+}
+
+// rowColumns appends keys missing from columns, in sorted order
+func rowColumns(columns []string, rows []map[string]any) []string {
+	seen := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		seen[column] = true
+	}
+
+	var extra []string
+	for _, row := range rows {
+		for k := range row {
+			if !seen[k] {
+				seen[k] = true
+				extra = append(extra, k)
+			}
+		}
+	}
+	sort.Strings(extra)
+
+	return append(append([]string{}, columns...), extra...)
+}
+
+func rowKey(row map[string]any, keys []string) string {
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = ValueString(row[key])
+	}
+
+	return strings.Join(parts, "\x00")
+}
+
+func inferValue(value string) any {
+	if value == "" {
+		return nil
+	}
+
+	if decimalLiteral.MatchString(value) {
+		if i, e := strconv.ParseInt(value, 10, 64); e == nil {
+			return i
+		}
+		// integers out of the int64 range stay strings rather than losing digits
+		if strings.Contains(value, ".") {
+			if fl, e := strconv.ParseFloat(value, 64); e == nil {
+				return fl
+			}
+		}
+	}
+
+	if value == "true" || value == "false" {
+		return value == "true"
+	}
+
+	return value
+}
+
+func numberValue(v any) any {
+	n, ok := v.(json.Number)
+	if !ok {
+		return v
+	}
+
+	if i, e := n.Int64(); e == nil {
+		return i
+	}
+
+	fl, _ := n.Float64()
+
+	return fl
+}
+
+func toRows(data any) ([]map[string]any, error) {
+	switch d := data.(type) {
+	case map[string]any:
+		return []map[string]any{d}, nil
+	case []map[string]any:
+		return d, nil
+	}
+
+	b, e := jsoniter.Marshal(data)
+	if e != nil {
+		return nil, e
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("[")) {
+		var rows []map[string]any
+		e = jsoniter.Unmarshal(b, &rows)
+		return rows, e
+	}
+
+	var row map[string]any
+	e = jsoniter.Unmarshal(b, &row)
+
+	return []map[string]any{row}, e
+}
@@ -0,0 +1,175 @@
+package ds_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/enorith/syncer/ds"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+func writeGzipGBK(t *testing.T, path, content string) {
+	encoded, e := simplifiedchinese.GBK.NewEncoder().String(content)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(encoded))
+	gz.Close()
+
+	if e := os.WriteFile(path, buf.Bytes(), 0644); e != nil {
+		t.Fatal(e)
+	}
+}
+
+func TestCSVSource(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "users.csv.gz")
+	writeGzipGBK(t, path, "id;name;score\n1;张三;9.5\n2;李四;7\n3;王五;\n4;赵六;8\n")
+
+	ds.RegisterDatasource("csv", ds.FileRegister)
+	source, e := ds.Connect("csv://" + path + "?delimiter=semicolon&encoding=gbk")
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	meta, e := source.ListMeta(ds.ListFilter{Field: "score", Op: ">=", Value: 8})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if meta.Total != 2 {
+		t.Fatalf("expected 2 rows, got %d", meta.Total)
+	}
+
+	res, e := source.List(ds.ListOption{Page: 2, Limit: 2})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if res.Meta.Total != 4 || len(res.Data) != 2 {
+		t.Fatalf("unexpected page: %+v", res)
+	}
+
+	row := res.Data[0].(map[string]any)
+	if row["id"] != int64(3) || row["name"] != "王五" || row["score"] != nil {
+		t.Fatalf("unexpected row: %v", row)
+	}
+
+	res, e = source.List(ds.ListOption{
+		Limit:   1,
+		Orders:  []ds.ListOrder{{Field: "score", Order: "desc"}},
+		Filters: []ds.ListFilter{{Field: "name", Op: "like", Value: "%四"}},
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(res.Data) != 1 || res.Data[0].(map[string]any)["score"] != int64(7) {
+		t.Fatalf("unexpected filtered rows: %v", res.Data)
+	}
+
+	if e := source.Create([]map[string]any{{"id": 5, "name": "孙七", "score": 6}}); e != nil {
+		t.Fatal(e)
+	}
+
+	found, e := source.Find(5)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if found.(map[string]any)["name"] != "孙七" {
+		t.Fatalf("unexpected appended row: %v", found)
+	}
+
+	if e := source.DeleteMany(ds.ListFilter{Field: "id", Op: "in", Value: []int{1, 2}}); e != nil {
+		t.Fatal(e)
+	}
+
+	meta, _ = source.ListMeta()
+	if meta.Total != 3 {
+		t.Fatalf("expected 3 rows after delete, got %d", meta.Total)
+	}
+}
+
+func TestCSVInfer(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "values.csv")
+	os.WriteFile(path, []byte("value\n0\n-12\n1.50\n007\n00.5\nNaN\ninf\nInfinity\n1e3\n0x1F\n+5\n.5\n99999999999999999999\ntrue\n"), 0644)
+
+	ds.RegisterDatasource("csv", ds.FileRegister)
+	source, e := ds.Connect("csv://" + path)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	res, e := source.List(ds.ListOption{Limit: 100})
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	want := []any{int64(0), int64(-12), 1.5, "007", "00.5", "NaN", "inf", "Infinity", "1e3", "0x1F", "+5", ".5", "99999999999999999999", true}
+	if len(res.Data) != len(want) {
+		t.Fatalf("unexpected rows: %v", res.Data)
+	}
+	for i, data := range res.Data {
+		if v := data.(map[string]any)["value"]; v != want[i] {
+			t.Errorf("row %d: expected %#v, got %#v", i, want[i], v)
+		}
+	}
+}
+
+func TestJSONLSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.jsonl")
+	os.WriteFile(path, []byte(`{"id":1,"name":"a","tags":["x"]}
+{"id":2,"name":"b","rate":0.5}
+
+{"id":3,"name":"c"}
+`), 0644)
+
+	ds.RegisterDatasource("jsonl", ds.FileRegister)
+	source, e := ds.Connect("jsonl://" + path)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	schema, e := source.(ds.SchemaProvider).Schema()
+	if e != nil {
+		t.Fatal(e)
+	}
+	names := make([]string, len(schema))
+	for i, column := range schema {
+		names[i] = column.Name
+	}
+	if fmt.Sprint(names) != "[id name rate tags]" {
+		t.Fatalf("expected the columns of every row, got %v", names)
+	}
+
+	res, e := source.List(ds.ListOption{
+		Filters: []ds.ListFilter{{Field: "id", Op: "between", Value: []any{2, 3}}},
+		Selects: []string{"id", "rate"},
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if res.Meta.Total != 2 {
+		t.Fatalf("expected 2 rows, got %d", res.Meta.Total)
+	}
+
+	row := res.Data[0].(map[string]any)
+	if row["id"] != int64(2) || row["rate"] != 0.5 || len(row) != 2 {
+		t.Fatalf("unexpected row: %v", row)
+	}
+
+	if e := source.Update(3, map[string]any{"name": "cc"}); e != nil {
+		t.Fatal(e)
+	}
+
+	found, _ := source.Find(3)
+	if found.(map[string]any)["name"] != "cc" {
+		t.Fatalf("unexpected updated row: %v", found)
+	}
+}
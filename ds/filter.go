@@ -0,0 +1,246 @@
+package ds
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MatchFilters evaluates filters in memory, for datasources which can not push them down
+func MatchFilters(row map[string]any, filters ...ListFilter) bool {
+	for _, filter := range filters {
+		if !MatchFilter(row, filter) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func MatchFilter(row map[string]any, filter ListFilter) bool {
+	value := row[filter.Field]
+
+	switch strings.ToLower(strings.TrimSpace(filter.Op)) {
+	case "", "=", "==":
+		return CompareValues(value, filter.Value) == 0
+	case "!=", "<>":
+		return CompareValues(value, filter.Value) != 0
+	case ">":
+		return value != nil && CompareValues(value, filter.Value) > 0
+	case ">=":
+		return value != nil && CompareValues(value, filter.Value) >= 0
+	case "<":
+		return value != nil && CompareValues(value, filter.Value) < 0
+	case "<=":
+		return value != nil && CompareValues(value, filter.Value) <= 0
+	case "in":
+		return inValues(value, filter.Value)
+	case "not in":
+		return !inValues(value, filter.Value)
+	case "like":
+		return likeMatch(value, filter.Value)
+	case "not like":
+		return !likeMatch(value, filter.Value)
+	case "between":
+		values := toSlice(filter.Value)
+		if len(values) != 2 || value == nil {
+			return false
+		}
+
+		return CompareValues(value, values[0]) >= 0 && CompareValues(value, values[1]) <= 0
+	case "is null":
+		return value == nil
+	case "is not null":
+		return value != nil
+	}
+
+	return false
+}
+
+// CompareValues compares a and b numerically when either side is a number, otherwise as strings,
+// nil sorts before any other value
+func CompareValues(a, b any) int {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return -1
+		default:
+			return 1
+		}
+	}
+
+	if isNumber(a) || isNumber(b) {
+		fa, okA := toFloat(a)
+		fb, okB := toFloat(b)
+		if okA && okB {
+			switch {
+			case fa < fb:
+				return -1
+			case fa > fb:
+				return 1
+			default:
+				return 0
+			}
+		}
+	}
+
+	return strings.Compare(ValueString(a), ValueString(b))
+}
+
+// ValueString formats a scalar value the way it would be written to a text file
+func ValueString(v any) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case []byte:
+		return string(val)
+	case time.Time:
+		return val.Format(DefaultTimeFormat)
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(val), 'f', -1, 32)
+	}
+
+	return fmt.Sprint(v)
+}
+
+// SortRows sorts rows in place by orders, in the same manner as ORDER BY
+func SortRows(rows []map[string]any, orders []ListOrder) {
+	if len(orders) == 0 {
+		return
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, order := range orders {
+			c := CompareValues(rows[i][order.Field], rows[j][order.Field])
+			if c == 0 {
+				continue
+			}
+
+			if strings.EqualFold(order.Order, "desc") {
+				return c > 0
+			}
+
+			return c < 0
+		}
+
+		return false
+	})
+}
+
+// ProjectRow keeps only the selected fields of row, all fields are kept without selects
+func ProjectRow(row map[string]any, selects []string) map[string]any {
+	if len(selects) == 0 {
+		return row
+	}
+
+	projected := make(map[string]any, len(selects))
+	for _, field := range selects {
+		if v, ok := row[field]; ok {
+			projected[field] = v
+		}
+	}
+
+	return projected
+}
+
+func inValues(value, values any) bool {
+	for _, v := range toSlice(values) {
+		if CompareValues(value, v) == 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func likeMatch(value, pattern any) bool {
+	if value == nil {
+		return false
+	}
+
+	var expr strings.Builder
+	expr.WriteString("(?is)^")
+	for _, r := range ValueString(pattern) {
+		switch r {
+		case '%':
+			expr.WriteString(".*")
+		case '_':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	expr.WriteString("$")
+
+	re, e := regexp.Compile(expr.String())
+	if e != nil {
+		return false
+	}
+
+	return re.MatchString(ValueString(value))
+}
+
+func toSlice(v any) []any {
+	if items, ok := v.([]any); ok {
+		return items
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return []any{v}
+	}
+
+	items := make([]any, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		items[i] = rv.Index(i).Interface()
+	}
+
+	return items
+}
+
+func isNumber(v any) bool {
+	switch v.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return true
+	}
+
+	return false
+}
+
+func toFloat(v any) (float64, bool) {
+	switch val := v.(type) {
+	case string:
+		f, e := strconv.ParseFloat(strings.TrimSpace(val), 64)
+		return f, e == nil
+	case []byte:
+		f, e := strconv.ParseFloat(strings.TrimSpace(string(val)), 64)
+		return f, e == nil
+	case bool:
+		if val {
+			return 1, true
+		}
+		return 0, true
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+
+	return 0, false
+}
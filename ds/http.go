@@ -0,0 +1,598 @@
+package ds
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+)
+
+const (
+	PaginationPage   = "page"
+	PaginationOffset = "offset"
+	PaginationCursor = "cursor"
+	PaginationLink   = "link"
+)
+
+var (
+	DefaultHTTPPageSize int64 = 100
+	// MaxRetryAfter caps the wait a Retry-After header asks for
+	MaxRetryAfter = time.Minute
+
+	linkNextRegexp = regexp.MustCompile(`<([^>]+)>\s*;[^,]*rel="?next"?`)
+)
+
+// HTTPOptions describes how a paginated json api is read
+type HTTPOptions struct {
+	// Pagination page (default), offset, cursor or link
+	Pagination  string
+	PageParam   string
+	SizeParam   string
+	OffsetParam string
+	CursorParam string
+	// CursorPath dot path of the next cursor token in the response, such as meta.next_cursor
+	CursorPath string
+	// RecordsPath dot path of the records array, the response itself when empty
+	RecordsPath string
+	// TotalPath dot path of the total count, pages are walked to count without it
+	TotalPath string
+	// SortParam receives orders as "field,-field"
+	SortParam string
+	// Headers values are expanded with environment variables, such as "Bearer ${API_TOKEN}"
+	Headers map[string]string
+	// RateLimit max requests per second, unlimited when zero
+	RateLimit float64
+	// MaxRetries retries of 429 and 503 responses, 3 when nil, Retry-After is honored up to MaxRetryAfter
+	MaxRetries *int
+	Timeout    time.Duration
+	PK         string
+	Client     *http.Client
+}
+
+// HTTP paginated json api datasource, equality filters are sent as query parameters
+type HTTP struct {
+	endpoint *url.URL
+	opts     HTTPOptions
+	client   *http.Client
+
+	limitMu sync.Mutex
+	last    time.Time
+	retries int
+
+	// walkMu serializes walking cursor pages, cursors holds the token of each page reached and
+	// ends the pages past the last one
+	walkMu   sync.Mutex
+	cursorMu sync.Mutex
+	cursors  map[string]string
+	ends     map[string]bool
+}
+
+// Sequential cursor and link pages are reached one after another
+func (h *HTTP) Sequential() bool {
+	return h.opts.Pagination == PaginationCursor || h.opts.Pagination == PaginationLink
+}
+
+func (h *HTTP) List(opt ListOption) (ListResult, error) {
+	result := ListResult{
+		Data: make([]any, 0),
+	}
+
+	if opt.Page < 1 {
+		opt.Page = 1
+	}
+	if opt.Limit <= 0 {
+		opt.Limit = DefaultHTTPPageSize
+	}
+
+	query, e := h.query(opt)
+	if e != nil {
+		return result, e
+	}
+
+	var body []byte
+	if h.Sequential() {
+		body, e = h.sequentialPage(query, opt.Page, opt.Limit)
+	} else {
+		body, _, e = h.get(h.pageURL(query, opt.Page, opt.Limit))
+	}
+	if e != nil {
+		return result, e
+	}
+
+	records, e := h.records(body)
+	if e != nil {
+		return result, e
+	}
+
+	for _, record := range records {
+		result.Data = append(result.Data, ProjectRow(record, opt.Selects))
+	}
+
+	if !opt.WithoutMeta {
+		if h.opts.TotalPath != "" {
+			result.Meta.Total = h.total(body)
+		} else {
+			result.Meta, e = h.ListMeta(opt.Filters...)
+		}
+	}
+
+	return result, e
+}
+
+func (h *HTTP) ListMeta(filters ...ListFilter) (ListMeta, error) {
+	var meta ListMeta
+
+	query, e := h.query(ListOption{Filters: filters})
+	if e != nil {
+		return meta, e
+	}
+
+	if h.opts.TotalPath != "" {
+		var body []byte
+		if h.Sequential() {
+			body, e = h.sequentialPage(query, 1, 1)
+		} else {
+			body, _, e = h.get(h.pageURL(query, 1, 1))
+		}
+		if e != nil {
+			return meta, e
+		}
+
+		meta.Total = h.total(body)
+
+		return meta, nil
+	}
+
+	// without a total every page is read to count until an empty page or the last cursor, short
+	// pages do not end it as apis may cap the page size, the syncer reads sequential sources
+	// without counting them first
+	for page := int64(1); ; page++ {
+		var body []byte
+		if h.Sequential() {
+			body, e = h.sequentialPage(query, page, DefaultHTTPPageSize)
+		} else {
+			body, _, e = h.get(h.pageURL(query, page, DefaultHTTPPageSize))
+		}
+		if e != nil {
+			return meta, e
+		}
+
+		records, e := h.records(body)
+		if e != nil {
+			return meta, e
+		}
+
+		meta.Total += int64(len(records))
+		if len(records) == 0 {
+			return meta, nil
+		}
+	}
+}
+
+func (h *HTTP) Find(id any) (any, error) {
+	body, _, e := h.do(http.MethodGet, h.itemURL(id), nil)
+	if e != nil {
+		return nil, e
+	}
+
+	var item map[string]any
+	e = jsonNumber.Unmarshal(body, &item)
+
+	return numberValues(item), e
+}
+
+func (h *HTTP) Create(data any) error {
+	_, _, e := h.do(http.MethodPost, h.endpoint.String(), data)
+
+	return e
+}
+
+func (h *HTTP) Update(id any, data any) error {
+	_, _, e := h.do(http.MethodPut, h.itemURL(id), data)
+
+	return e
+}
+
+func (h *HTTP) UpdateMany(data any, filters ...ListFilter) error {
+	return ErrNotSupported
+}
+
+func (h *HTTP) Delete(id any) error {
+	_, _, e := h.do(http.MethodDelete, h.itemURL(id), nil)
+
+	return e
+}
+
+func (h *HTTP) DeleteMany(filters ...ListFilter) error {
+	return ErrNotSupported
+}
+
+// sequentialPage fetches a cursor or link paginated page, walking from the nearest known page,
+// walks are serialized so concurrent callers reuse the cursors found instead of walking again
+func (h *HTTP) sequentialPage(query url.Values, page, limit int64) ([]byte, error) {
+	h.walkMu.Lock()
+	defer h.walkMu.Unlock()
+
+	known := page
+	for known > 1 {
+		if h.pastEnd(query, known, limit) {
+			return nil, nil
+		}
+		if _, ok := h.cursor(query, known, limit); ok {
+			break
+		}
+		known--
+	}
+
+	for {
+		token, _ := h.cursor(query, known, limit)
+
+		var u string
+		if h.opts.Pagination == PaginationLink && token != "" {
+			u = token
+		} else {
+			q := cloneValues(query)
+			q.Set(h.param(h.opts.SizeParam, "size"), strconv.FormatInt(limit, 10))
+			if token != "" {
+				q.Set(h.param(h.opts.CursorParam, "cursor"), token)
+			}
+			u = h.withQuery(q)
+		}
+
+		body, header, e := h.get(u)
+		if e != nil {
+			return nil, e
+		}
+
+		var next string
+		if h.opts.Pagination == PaginationLink {
+			if m := linkNextRegexp.FindStringSubmatch(header.Get("Link")); len(m) == 2 {
+				ref, e := url.Parse(m[1])
+				if e != nil {
+					return nil, fmt.Errorf("[datasource] http link next %q: %w", m[1], e)
+				}
+				next = h.endpoint.ResolveReference(ref).String()
+			}
+		} else if h.opts.CursorPath != "" {
+			next = jsonNumber.Get(body, jsonPath(h.opts.CursorPath)...).ToString()
+		}
+
+		if next != "" {
+			h.setCursor(query, known+1, limit, next)
+		} else {
+			h.setEnd(query, known+1, limit)
+		}
+
+		if known == page {
+			return body, nil
+		}
+
+		if next == "" {
+			return nil, nil
+		}
+		known++
+	}
+}
+
+func (h *HTTP) cursor(query url.Values, page, limit int64) (string, bool) {
+	if page <= 1 {
+		return "", true
+	}
+
+	h.cursorMu.Lock()
+	defer h.cursorMu.Unlock()
+	token, ok := h.cursors[cursorKey(query, page, limit)]
+
+	return token, ok
+}
+
+func (h *HTTP) setCursor(query url.Values, page, limit int64, token string) {
+	h.cursorMu.Lock()
+	defer h.cursorMu.Unlock()
+	h.cursors[cursorKey(query, page, limit)] = token
+}
+
+func (h *HTTP) pastEnd(query url.Values, page, limit int64) bool {
+	h.cursorMu.Lock()
+	defer h.cursorMu.Unlock()
+
+	return h.ends[cursorKey(query, page, limit)]
+}
+
+func (h *HTTP) setEnd(query url.Values, page, limit int64) {
+	h.cursorMu.Lock()
+	defer h.cursorMu.Unlock()
+	h.ends[cursorKey(query, page, limit)] = true
+}
+
+func (h *HTTP) query(opt ListOption) (url.Values, error) {
+	query := h.endpoint.Query()
+
+	for _, filter := range opt.Filters {
+		switch strings.TrimSpace(filter.Op) {
+		case "", "=", "==":
+			query.Set(filter.Field, ValueString(filter.Value))
+		default:
+			return nil, fmt.Errorf("%w: http filter op %s", ErrNotSupported, filter.Op)
+		}
+	}
+
+	if len(opt.Orders) > 0 && h.opts.SortParam != "" {
+		sorts := make([]string, len(opt.Orders))
+		for i, order := range opt.Orders {
+			if strings.EqualFold(order.Order, "desc") {
+				sorts[i] = "-" + order.Field
+			} else {
+				sorts[i] = order.Field
+			}
+		}
+		query.Set(h.opts.SortParam, strings.Join(sorts, ","))
+	}
+
+	return query, nil
+}
+
+func (h *HTTP) pageURL(query url.Values, page, limit int64) string {
+	q := cloneValues(query)
+
+	if h.opts.Pagination == PaginationOffset {
+		q.Set(h.param(h.opts.OffsetParam, "offset"), strconv.FormatInt((page-1)*limit, 10))
+		q.Set(h.param(h.opts.SizeParam, "limit"), strconv.FormatInt(limit, 10))
+	} else {
+		q.Set(h.param(h.opts.PageParam, "page"), strconv.FormatInt(page, 10))
+		q.Set(h.param(h.opts.SizeParam, "size"), strconv.FormatInt(limit, 10))
+	}
+
+	return h.withQuery(q)
+}
+
+func (h *HTTP) itemURL(id any) string {
+	u := *h.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + url.PathEscape(ValueString(id))
+
+	return u.String()
+}
+
+func (h *HTTP) withQuery(q url.Values) string {
+	u := *h.endpoint
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
+
+func (h *HTTP) records(body []byte) ([]map[string]any, error) {
+	if body == nil {
+		return nil, nil
+	}
+
+	node := jsonNumber.Get(body, jsonPath(h.opts.RecordsPath)...)
+	if node.LastError() != nil {
+		return nil, fmt.Errorf("[datasource] http records not found at %q: %w", h.opts.RecordsPath, node.LastError())
+	}
+
+	items, ok := node.GetInterface().([]any)
+	if !ok {
+		return nil, fmt.Errorf("[datasource] http records at %q is not an array", h.opts.RecordsPath)
+	}
+
+	records := make([]map[string]any, 0, len(items))
+	for _, item := range items {
+		if record, ok := item.(map[string]any); ok {
+			records = append(records, numberValues(record))
+		}
+	}
+
+	return records, nil
+}
+
+func (h *HTTP) total(body []byte) int64 {
+	return jsonNumber.Get(body, jsonPath(h.opts.TotalPath)...).ToInt64()
+}
+
+func (h *HTTP) get(u string) ([]byte, http.Header, error) {
+	return h.do(http.MethodGet, u, nil)
+}
+
+func (h *HTTP) do(method, u string, data any) ([]byte, http.Header, error) {
+	var payload []byte
+	if data != nil {
+		var e error
+		if payload, e = jsoniter.Marshal(data); e != nil {
+			return nil, nil, e
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		h.wait()
+
+		req, e := http.NewRequest(method, u, bytes.NewReader(payload))
+		if e != nil {
+			return nil, nil, e
+		}
+
+		req.Header.Set("Accept", "application/json")
+		if data != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		for k, v := range h.opts.Headers {
+			req.Header.Set(k, os.ExpandEnv(v))
+		}
+
+		resp, e := h.client.Do(req)
+		if e != nil {
+			return nil, nil, e
+		}
+
+		body, e := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if e != nil {
+			return nil, nil, e
+		}
+
+		if (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) && attempt < h.retries {
+			<-time.After(RetryAfter(resp.Header, attempt))
+			continue
+		}
+
+		if resp.StatusCode >= 300 {
+			return nil, nil, fmt.Errorf("[datasource] http %s %s: %s", method, u, resp.Status)
+		}
+
+		return body, resp.Header, nil
+	}
+}
+
+// wait blocks until the next request is allowed by the rate limit
+func (h *HTTP) wait() {
+	if h.opts.RateLimit <= 0 {
+		return
+	}
+
+	h.limitMu.Lock()
+	defer h.limitMu.Unlock()
+
+	interval := time.Duration(float64(time.Second) / h.opts.RateLimit)
+	if d := time.Until(h.last.Add(interval)); d > 0 {
+		<-time.After(d)
+	}
+	h.last = time.Now()
+}
+
+func (h *HTTP) param(name, def string) string {
+	if name == "" {
+		return def
+	}
+
+	return name
+}
+
+// RetryAfter parses the Retry-After header (seconds or http date) capped at MaxRetryAfter,
+// falling back to a linear backoff
+func RetryAfter(header http.Header, attempt int) time.Duration {
+	if v := header.Get("Retry-After"); v != "" {
+		if seconds, e := strconv.Atoi(v); e == nil {
+			return capRetryAfter(time.Duration(seconds) * time.Second)
+		}
+
+		if at, e := http.ParseTime(v); e == nil {
+			return capRetryAfter(time.Until(at))
+		}
+	}
+
+	return time.Duration(attempt+1) * time.Second
+}
+
+func capRetryAfter(d time.Duration) time.Duration {
+	if d < 0 {
+		return 0
+	}
+
+	return min(d, MaxRetryAfter)
+}
+
+func NewHTTP(endpoint *url.URL, opts HTTPOptions) *HTTP {
+	retries := 3
+	if opts.MaxRetries != nil {
+		retries = *opts.MaxRetries
+	}
+
+	if opts.PK == "" {
+		opts.PK = "id"
+	}
+
+	client := opts.Client
+	if client == nil {
+		client = &http.Client{Timeout: opts.Timeout}
+	}
+
+	return &HTTP{endpoint: endpoint, opts: opts, client: client, retries: retries,
+		cursors: make(map[string]string), ends: make(map[string]bool)}
+}
+
+var (
+	httpOptions = make(map[string]HTTPOptions)
+	httpLock    = new(sync.RWMutex)
+)
+
+func RegisterHTTPOptions(name string, opts HTTPOptions) {
+	httpLock.Lock()
+	defer httpLock.Unlock()
+	httpOptions[name] = opts
+}
+
+func GetHTTPOptions(name string) (HTTPOptions, bool) {
+	httpLock.RLock()
+	defer httpLock.RUnlock()
+	opts, ok := httpOptions[name]
+	return opts, ok
+}
+
+// HTTPRegister connects http and https datasources, options are registered
+// by name and referenced with the ds_options query parameter, or by host
+func HTTPRegister(u *url.URL) (Datasource, error) {
+	if u.Host == "" {
+		return nil, errors.New("[datasource] http host is required")
+	}
+
+	endpoint := *u
+	query := endpoint.Query()
+	name := query.Get("ds_options")
+	query.Del("ds_options")
+	endpoint.RawQuery = query.Encode()
+
+	opts, ok := GetHTTPOptions(name)
+	if !ok {
+		opts, _ = GetHTTPOptions(u.Host)
+	}
+
+	return NewHTTP(&endpoint, opts), nil
+}
+
+func jsonPath(path string) []any {
+	if path == "" {
+		return nil
+	}
+
+	parts := strings.Split(path, ".")
+	segments := make([]any, len(parts))
+	for i, part := range parts {
+		if index, e := strconv.Atoi(part); e == nil {
+			segments[i] = index
+		} else {
+			segments[i] = part
+		}
+	}
+
+	return segments
+}
+
+func numberValues(row map[string]any) map[string]any {
+	for k, v := range row {
+		row[k] = numberValue(v)
+	}
+
+	return row
+}
+
+func cloneValues(values url.Values) url.Values {
+	c := make(url.Values, len(values))
+	for k, v := range values {
+		c[k] = append([]string{}, v...)
+	}
+
+	return c
+}
+
+func cursorKey(query url.Values, page, limit int64) string {
+	return fmt.Sprintf("%s#%d#%d", query.Encode(), limit, page)
+}
@@ -0,0 +1,193 @@
+package ds_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/enorith/syncer/ds"
+	jsoniter "github.com/json-iterator/go"
+)
+
+func newUsersAPI(t *testing.T, total int) *httptest.Server {
+	var throttled int32
+
+	users := make([]map[string]any, total)
+	for i := range users {
+		users[i] = map[string]any{"id": i + 1, "name": fmt.Sprintf("user%d", i+1)}
+	}
+
+	write := func(w http.ResponseWriter, v any) {
+		b, _ := jsoniter.Marshal(v)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/paged", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if atomic.CompareAndSwapInt32(&throttled, 0, 1) {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+		start, end := min((page-1)*size, total), min(page*size, total)
+
+		write(w, map[string]any{
+			"data": map[string]any{"items": users[start:end]},
+			"meta": map[string]any{"total": total},
+		})
+	})
+
+	// capped serves at most 2 users a page whatever the size asked, without a total
+	mux.HandleFunc("/capped", func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+		size = min(size, 2)
+		start, end := min((page-1)*size, total), min(page*size, total)
+
+		write(w, users[start:end])
+	})
+
+	mux.HandleFunc("/cursor", func(w http.ResponseWriter, r *http.Request) {
+		start, _ := strconv.Atoi(r.URL.Query().Get("after"))
+		size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+		end := min(start+size, total)
+
+		next := ""
+		if end < total {
+			next = strconv.Itoa(end)
+		}
+		write(w, map[string]any{"items": users[start:end], "next": next})
+	})
+
+	mux.HandleFunc("/link", func(w http.ResponseWriter, r *http.Request) {
+		start, _ := strconv.Atoi(r.URL.Query().Get("from"))
+		size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+		end := min(start+size, total)
+
+		if end < total {
+			w.Header().Set("Link", fmt.Sprintf(`</link?from=%d&size=%d>; rel="next"`, end, size))
+		}
+		write(w, users[start:end])
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestHTTPSource(t *testing.T) {
+	server := newUsersAPI(t, 7)
+	t.Setenv("USERS_API_TOKEN", "secret")
+
+	ds.RegisterDatasource("http", ds.HTTPRegister)
+	ds.RegisterHTTPOptions("paged", ds.HTTPOptions{
+		RecordsPath: "data.items",
+		TotalPath:   "meta.total",
+		Headers:     map[string]string{"Authorization": "Bearer ${USERS_API_TOKEN}"},
+		RateLimit:   100,
+	})
+	ds.RegisterHTTPOptions("cursor", ds.HTTPOptions{
+		Pagination:  ds.PaginationCursor,
+		CursorParam: "after",
+		CursorPath:  "next",
+		RecordsPath: "items",
+	})
+	ds.RegisterHTTPOptions("link", ds.HTTPOptions{
+		Pagination: ds.PaginationLink,
+	})
+
+	for _, name := range []string{"paged", "cursor", "link"} {
+		source, e := ds.Connect(server.URL + "/" + name + "?ds_options=" + name)
+		if e != nil {
+			t.Fatal(e)
+		}
+
+		meta, e := source.ListMeta()
+		if e != nil {
+			t.Fatal(name, e)
+		}
+		if meta.Total != 7 {
+			t.Fatalf("%s: expected total 7, got %d", name, meta.Total)
+		}
+
+		res, e := source.List(ds.ListOption{Page: 3, Limit: 3, WithoutMeta: true})
+		if e != nil {
+			t.Fatal(name, e)
+		}
+		if len(res.Data) != 1 || res.Data[0].(map[string]any)["id"] != int64(7) {
+			t.Fatalf("%s: unexpected last page: %v", name, res.Data)
+		}
+
+		res, e = source.List(ds.ListOption{Page: 5, Limit: 3, WithoutMeta: true})
+		if e != nil {
+			t.Fatal(name, e)
+		}
+		if len(res.Data) != 0 {
+			t.Fatalf("%s: expected empty page, got %v", name, res.Data)
+		}
+	}
+
+	// pages shorter than asked do not end counting without a total
+	capped, _ := ds.Connect(server.URL + "/capped")
+	if meta, e := capped.ListMeta(); e != nil || meta.Total != 7 {
+		t.Fatalf("capped: expected total 7, got %d %v", meta.Total, e)
+	}
+
+	source, _ := ds.Connect(server.URL + "/paged?ds_options=paged")
+	if _, e := source.List(ds.ListOption{Filters: []ds.ListFilter{{Field: "id", Op: ">", Value: 1}}}); e == nil {
+		t.Fatal("expected unsupported filter error")
+	}
+}
+
+func TestHTTPMaxRetries(t *testing.T) {
+	server := newUsersAPI(t, 3)
+
+	retries := 0
+	source := ds.NewHTTP(mustParse(t, server.URL+"/paged"), ds.HTTPOptions{
+		RecordsPath: "data.items",
+		Headers:     map[string]string{"Authorization": "Bearer secret"},
+		MaxRetries:  &retries,
+	})
+
+	if _, e := source.List(ds.ListOption{WithoutMeta: true}); e == nil || !strings.Contains(e.Error(), "429") {
+		t.Fatalf("expected throttled request not to be retried, got %v", e)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	for value, want := range map[string]time.Duration{
+		"2":     2 * time.Second,
+		"86400": ds.MaxRetryAfter,
+		time.Now().Add(24 * time.Hour).UTC().Format(http.TimeFormat): ds.MaxRetryAfter,
+		time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat):     0,
+	} {
+		if d := ds.RetryAfter(http.Header{"Retry-After": {value}}, 0); d != want {
+			t.Errorf("Retry-After %s: expected %s, got %s", value, want, d)
+		}
+	}
+}
+
+func mustParse(t *testing.T, raw string) *url.URL {
+	u, e := url.Parse(raw)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	return u
+}
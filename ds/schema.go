@@ -0,0 +1,74 @@
+package ds
+
+import (
+	"strings"
+)
+
+// normalized column types shared by datasources, resolvers and targets
+const (
+	TypeString = "string"
+	TypeInt    = "int"
+	TypeFloat  = "float"
+	// TypeDecimal exact numbers, sized by Column.Precision and Column.Scale when known
+	TypeDecimal = "decimal"
+	TypeBool    = "bool"
+	// TypeTime date and time, TypeDate a date only, TypeTimeOfDay a time without a date
+	TypeTime      = "time"
+	TypeDate      = "date"
+	TypeTimeOfDay = "time_of_day"
+	TypeBytes     = "bytes"
+)
+
+type Column struct {
+	Name string
+	// Type normalized type, one of the Type constants
+	Type string
+	// DatabaseType type name reported by the datasource, such as VARCHAR
+	DatabaseType string
+	Length       int64
+	// Precision and Scale of decimal columns, 0 when unknown
+	Precision  int64
+	Scale      int64
+	Nullable   bool
+	PrimaryKey bool
+}
+
+// SchemaProvider is implemented by datasources which can describe their columns
+type SchemaProvider interface {
+	Schema() ([]Column, error)
+}
+
+var databaseTypes = map[string]string{
+	"tinyint": TypeInt, "smallint": TypeInt, "mediumint": TypeInt, "int": TypeInt, "integer": TypeInt, "bigint": TypeInt,
+	"int2": TypeInt, "int4": TypeInt, "int8": TypeInt, "serial": TypeInt, "bigserial": TypeInt, "smallserial": TypeInt, "year": TypeInt,
+
+	"float": TypeFloat, "double": TypeFloat, "double precision": TypeFloat, "real": TypeFloat, "float4": TypeFloat, "float8": TypeFloat,
+
+	"decimal": TypeDecimal, "numeric": TypeDecimal, "money": TypeDecimal,
+
+	"bool": TypeBool, "boolean": TypeBool, "bit": TypeBool,
+
+	"datetime": TypeTime, "timestamp": TypeTime, "timestamptz": TypeTime,
+	"timestamp without time zone": TypeTime, "timestamp with time zone": TypeTime,
+
+	"date": TypeDate,
+
+	"time": TypeTimeOfDay, "timetz": TypeTimeOfDay, "time without time zone": TypeTimeOfDay, "time with time zone": TypeTimeOfDay,
+
+	"blob": TypeBytes, "tinyblob": TypeBytes, "mediumblob": TypeBytes, "longblob": TypeBytes,
+	"binary": TypeBytes, "varbinary": TypeBytes, "bytea": TypeBytes,
+}
+
+// NormalizeType maps a database type name such as BIGINT UNSIGNED or varchar(64) to a Type constant,
+// unknown types are taken as strings
+func NormalizeType(databaseType string) string {
+	name := strings.ToLower(strings.TrimSpace(databaseType))
+	name = strings.SplitN(name, "(", 2)[0]
+	name = strings.TrimSpace(strings.TrimSuffix(name, " unsigned"))
+
+	if typ, ok := databaseTypes[name]; ok {
+		return typ
+	}
+
+	return TypeString
+}
@@ -0,0 +1,26 @@
+package ds_test
+
+import (
+	"testing"
+
+	"github.com/enorith/syncer/ds"
+)
+
+func TestNormalizeType(t *testing.T) {
+	for databaseType, want := range map[string]string{
+		"DECIMAL(10,2)":          ds.TypeDecimal,
+		"numeric":                ds.TypeDecimal,
+		"money":                  ds.TypeDecimal,
+		"DOUBLE PRECISION":       ds.TypeFloat,
+		"DATE":                   ds.TypeDate,
+		"TIMETZ":                 ds.TypeTimeOfDay,
+		"time without time zone": ds.TypeTimeOfDay,
+		"TIMESTAMPTZ":            ds.TypeTime,
+		"BIGINT UNSIGNED":        ds.TypeInt,
+		"jsonb":                  ds.TypeString,
+	} {
+		if typ := ds.NormalizeType(databaseType); typ != want {
+			t.Errorf("%s: expected %s, got %s", databaseType, want, typ)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package ds
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/enorith/gormdb"
+)
+
+// SQLQueryAlias the alias of the derived table a query is wrapped in, filters and orders
+// can qualify its columns with it
+const SQLQueryAlias = "sql_query"
+
+var (
+	sqlQueries = make(map[string]string)
+	sqlLock    = new(sync.RWMutex)
+)
+
+func RegisterSQLQuery(name, query string) {
+	sqlLock.Lock()
+	defer sqlLock.Unlock()
+	sqlQueries[name] = query
+}
+
+func GetSQLQuery(name string) (string, bool) {
+	sqlLock.RLock()
+	defer sqlLock.RUnlock()
+	query, ok := sqlQueries[name]
+	return query, ok
+}
+
+// SQL read only datasource of a query, wrapped as a derived table so list options apply on top of it
+type SQL struct {
+	*DB
+}
+
+func (s *SQL) Create(data any) error {
+	return ErrNotSupported
+}
+
+func (s *SQL) Upsert(data any, keys ...string) error {
+	return ErrNotSupported
+}
+
+func (s *SQL) Update(id any, data any) error {
+	return ErrNotSupported
+}
+
+func (s *SQL) UpdateMany(data any, filters ...ListFilter) error {
+	return ErrNotSupported
+}
+
+func (s *SQL) Delete(id any) error {
+	return ErrNotSupported
+}
+
+func (s *SQL) DeleteMany(filters ...ListFilter) error {
+	return ErrNotSupported
+}
+
+// Schema columns of the query result, read from an empty result set
+func (s *SQL) Schema() ([]Column, error) {
+	rows, e := s.newSession().Table(s.table).Limit(0).Rows()
+	if e != nil {
+		return nil, e
+	}
+	defer rows.Close()
+
+	types, e := rows.ColumnTypes()
+	if e != nil {
+		return nil, e
+	}
+
+	columns := make([]Column, len(types))
+	for i, ct := range types {
+		columns[i] = Column{Name: ct.Name(), DatabaseType: ct.DatabaseTypeName(), Nullable: true}
+		columns[i].Type = NormalizeType(columns[i].DatabaseType)
+		if length, ok := ct.Length(); ok {
+			columns[i].Length = length
+		}
+		if precision, scale, ok := ct.DecimalSize(); ok && columns[i].Type == TypeDecimal {
+			columns[i].Precision, columns[i].Scale = precision, scale
+		}
+	}
+
+	return columns, nil
+}
+
+// SQLRegister registers sql://conn?query=name of a query registered by RegisterSQLQuery,
+// or sql://conn?file=path of a query read from a file, pk defaults to id and can list composite keys
+func SQLRegister(u *url.URL) (Datasource, error) {
+	var query string
+	name, file := u.Query().Get("query"), u.Query().Get("file")
+	switch {
+	case name != "":
+		q, ok := GetSQLQuery(name)
+		if !ok {
+			return nil, fmt.Errorf("[datasource] sql query not found: %s", name)
+		}
+		query = q
+	case file != "":
+		b, e := os.ReadFile(file)
+		if e != nil {
+			return nil, e
+		}
+		query = string(b)
+	default:
+		return nil, errors.New("[datasource] sql query or file is required")
+	}
+
+	query = strings.TrimRight(strings.TrimSpace(query), "; \t\r\n")
+	if query == "" {
+		return nil, errors.New("[datasource] sql query is empty")
+	}
+
+	db, e := gormdb.DefaultManager.GetConnection(u.Host)
+	if e != nil {
+		return nil, e
+	}
+
+	return &SQL{DB: NewDB(db, NewDBConfig{
+		Table: fmt.Sprintf("(%s) AS %s", query, SQLQueryAlias),
+		PK:    u.Query().Get("pk"),
+		Model: MapModel(SQLQueryAlias),
+	})}, nil
+}
@@ -0,0 +1,59 @@
+package ds_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/enorith/gormdb"
+	"github.com/enorith/syncer/ds"
+	"gorm.io/gorm"
+)
+
+func TestSQLRegister(t *testing.T) {
+	ds.RegisterDatasource("sql", ds.SQLRegister)
+	ds.RegisterSQLQuery("blank", " ;\n")
+
+	cases := map[string]string{
+		"sql://default":                   "query or file is required",
+		"sql://default?query=missing":     "query not found",
+		"sql://default?query=blank":       "query is empty",
+		"sql://default?file=/missing.sql": "no such file",
+	}
+
+	for conn, want := range cases {
+		if _, e := ds.Connect(conn); e == nil || !strings.Contains(e.Error(), want) {
+			t.Errorf("%s: expected error %q, got %v", conn, want, e)
+		}
+	}
+}
+
+func TestSQLListSQL(t *testing.T) {
+	gormDB, recorder := dryRunDB(t, "postgres")
+	gormdb.DefaultManager.Register("sql_dry_run", func() (*gorm.DB, error) {
+		return gormDB, nil
+	})
+
+	ds.RegisterDatasource("sql", ds.SQLRegister)
+	ds.RegisterSQLQuery("active_users", "SELECT id, name FROM users WHERE active;\n")
+	source, e := ds.Connect("sql://sql_dry_run?query=active_users")
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	filters := []ds.ListFilter{{Field: "sql_query.id", Op: ">", Value: 1}}
+	if _, e := source.List(ds.ListOption{Filters: filters, Orders: []ds.ListOrder{{Field: "name", Order: "desc"}}, Limit: 10, Page: 3}); e != nil {
+		t.Fatal(e)
+	}
+	if _, e := source.ListMeta(filters...); e != nil {
+		t.Fatal(e)
+	}
+
+	want := []string{
+		`SELECT count(*) FROM (SELECT * FROM (SELECT id, name FROM users WHERE active) AS sql_query WHERE sql_query.id > 1) aggragate`,
+		`SELECT * FROM (SELECT id, name FROM users WHERE active) AS sql_query WHERE sql_query.id > 1 ORDER BY name desc LIMIT 10 OFFSET 20`,
+		`SELECT count(*) FROM (SELECT * FROM (SELECT id, name FROM users WHERE active) AS sql_query WHERE sql_query.id > 1) aggragate`,
+	}
+	if strings.Join(recorder.sqls, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected sql:\n%s", strings.Join(recorder.sqls, "\n"))
+	}
+}
@@ -0,0 +1,88 @@
+package syncer
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/enorith/syncer/ds"
+)
+
+// TaskTarget one of the targets a task writes to, each page of the source is read once and
+// dispatched to every target
+type TaskTarget struct {
+	Target       string       `json:"target"`
+	TargetConfig TargetConfig `json:"target_config"`
+	// Mapping defaults to the mapping of the task
+	Mapping map[string]string `json:"mapping"`
+}
+
+// taskTargets the targets of a task, Target and TargetConfig when Targets is empty
+func (task SyncerTask) taskTargets() []TaskTarget {
+	if len(task.Targets) == 0 {
+		return []TaskTarget{{Target: task.Target, TargetConfig: task.TargetConfig, Mapping: task.Mapping}}
+	}
+
+	targets := make([]TaskTarget, len(task.Targets))
+	for i, tt := range task.Targets {
+		if tt.Mapping == nil {
+			tt.Mapping = task.Mapping
+		}
+		targets[i] = tt
+	}
+
+	return targets
+}
+
+// runTarget state of a target during a run, a failed target gets no more pages
+type runTarget struct {
+	TaskTarget
+	target  Target
+	mapping *MappingRun
+	dedup   *deduper
+	meta    *SyncMeta
+	// aggregation groups of the mapped rows, written after the last page
+	aggregation *ds.Aggregation
+
+	mu     sync.Mutex
+	err    error
+	failed atomic.Bool
+}
+
+func (rt *runTarget) fail(e error) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	if rt.err == nil {
+		rt.err = e
+	}
+	rt.failed.Store(true)
+}
+
+// failPage counts a page which was not written, failing the target when stop is set
+func (rt *runTarget) failPage(page int64, e error, stop bool) {
+	atomic.AddInt64(&rt.meta.FailedPages, 1)
+	if stop {
+		rt.fail(e)
+		return
+	}
+
+	log.Printf("[syncer] task %s, target %s: page %d failed: %v", rt.meta.TaskID, rt.Target, page, e)
+}
+
+// finish sets the status of the target meta and adds its counters to the run meta
+func (rt *runTarget) finish(run *SyncMeta) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	rt.meta.Status = SyncStatusSuccess
+	if rt.err != nil {
+		rt.meta.Status = SyncStatusFailed
+		rt.meta.Error = rt.err
+	}
+
+	run.Inserted += rt.meta.Inserted
+	run.Updated += rt.meta.Updated
+	run.Unchanged += rt.meta.Unchanged
+	run.FailedPages += rt.meta.FailedPages
+}
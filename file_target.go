@@ -0,0 +1,676 @@
+package syncer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/enorith/syncer/ds"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress"
+	pgzip "github.com/parquet-go/parquet-go/compress/gzip"
+	"github.com/parquet-go/parquet-go/compress/snappy"
+	"github.com/parquet-go/parquet-go/compress/zstd"
+)
+
+const (
+	FileFormatCSV     = "csv"
+	FileFormatJSONL   = "jsonl"
+	FileFormatParquet = "parquet"
+)
+
+// FileTargetConfig path is a text/template rendered with .TaskID, .Version, .Date (20060102),
+// .Time and .Part, when RotateRows is set and .Part is not used, a -0001 suffix is added
+type FileTargetConfig struct {
+	Path    string   `json:"path"`
+	Format  string   `json:"format"`
+	Columns []string `json:"columns"`
+	// Compression gzip for csv and jsonl, snappy, gzip or zstd for parquet
+	Compression string `json:"compression"`
+	RotateRows  int64  `json:"rotate_rows"`
+	Delimiter   string `json:"delimiter"`
+}
+
+type FilePathVars struct {
+	TaskID  string
+	Version int
+	Date    string
+	Time    time.Time
+	Part    int
+}
+
+// FileTarget exports synced rows to csv, json lines or parquet files,
+// rows are written to temp files which are renamed in AfterSync on success
+type FileTarget struct {
+	runs map[*SyncMeta]*fileRun
+	mu   sync.Mutex
+}
+
+type fileRun struct {
+	config  FileTargetConfig
+	tpl     *template.Template
+	vars    FilePathVars
+	columns []string
+	// mapped target columns of the run, types holds the parquet kinds of the columns once known
+	mapped  []ds.Column
+	types   map[string]string
+	current *filePart
+	parts   []*filePart
+	mu      sync.Mutex
+}
+
+type filePart struct {
+	file    *os.File
+	path    string
+	rows    int64
+	writer  rowWriter
+	closers []io.Closer
+}
+
+type rowWriter interface {
+	write(rows []map[string]any) error
+	close() error
+}
+
+// config decodes a target config, parsing its path template
+func (ft *FileTarget) config(conf TargetConfig) (FileTargetConfig, *template.Template, error) {
+	var config FileTargetConfig
+	if e := conf.Unmarshal(&config); e != nil {
+		return config, nil, e
+	}
+
+	if config.Path == "" {
+		return config, nil, errors.New("[target] file path is required")
+	}
+
+	if config.Format == "" {
+		config.Format = fileFormat(config.Path)
+	}
+
+	switch config.Format {
+	case FileFormatCSV, FileFormatJSONL, FileFormatParquet:
+	default:
+		return config, nil, fmt.Errorf("[target] unsupported file format: %s", config.Format)
+	}
+
+	tpl, e := template.New("path").Parse(config.Path)
+
+	return config, tpl, e
+}
+
+func (ft *FileTarget) ValidateConfig(conf TargetConfig) error {
+	_, _, e := ft.config(conf)
+
+	return e
+}
+
+func (ft *FileTarget) BeforeSync(conf TargetConfig, meta *SyncMeta) error {
+	config, tpl, e := ft.config(conf)
+	if e != nil {
+		return e
+	}
+
+	now := time.Now()
+	run := &fileRun{
+		config:  config,
+		tpl:     tpl,
+		columns: config.Columns,
+		mapped:  meta.Columns,
+		types:   make(map[string]string, len(meta.Columns)),
+		vars: FilePathVars{
+			TaskID:  meta.TaskID,
+			Version: meta.Version,
+			Date:    now.Format("20060102"),
+			Time:    now,
+		},
+	}
+
+	if run.vars.Version == 0 {
+		run.vars.Version = int(now.Unix())
+	}
+
+	if e := run.nextPart(); e != nil {
+		return e
+	}
+
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	ft.runs[meta] = run
+
+	return nil
+}
+
+func (ft *FileTarget) SyncFrom(conf TargetConfig, data []map[string]any, meta *SyncMeta) error {
+	ft.mu.Lock()
+	run, ok := ft.runs[meta]
+	ft.mu.Unlock()
+
+	if !ok {
+		return errors.New("[target] file target is not prepared, BeforeSync is required")
+	}
+
+	return run.write(data)
+}
+
+func (ft *FileTarget) AfterSync(conf TargetConfig, meta *SyncMeta) error {
+	ft.mu.Lock()
+	run, ok := ft.runs[meta]
+	delete(ft.runs, meta)
+	ft.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	return run.finish(meta.Status == SyncStatusSuccess)
+}
+
+func (r *fileRun) write(data []map[string]any) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.columns) == 0 {
+		r.columns = runColumns(r.mapped, data)
+	}
+
+	for len(data) > 0 {
+		batch := data
+		if r.config.RotateRows > 0 {
+			if r.current.rows >= r.config.RotateRows {
+				if e := r.nextPart(); e != nil {
+					return e
+				}
+			}
+
+			if room := r.config.RotateRows - r.current.rows; int64(len(batch)) > room {
+				batch = batch[:room]
+			}
+		}
+
+		if r.current.writer == nil {
+			w, e := r.newWriter(r.current)
+			if e != nil {
+				return e
+			}
+			r.current.writer = w
+		}
+
+		if e := r.current.writer.write(batch); e != nil {
+			return e
+		}
+
+		r.current.rows += int64(len(batch))
+		data = data[len(batch):]
+	}
+
+	return nil
+}
+
+func (r *fileRun) nextPart() error {
+	if r.current != nil {
+		if e := r.current.close(); e != nil {
+			return e
+		}
+	}
+
+	r.vars.Part = len(r.parts) + 1
+
+	var buf bytes.Buffer
+	if e := r.tpl.Execute(&buf, r.vars); e != nil {
+		return e
+	}
+
+	path := buf.String()
+	if r.config.RotateRows > 0 && !strings.Contains(r.config.Path, ".Part") {
+		path = partPath(path, r.vars.Part)
+	}
+
+	if e := os.MkdirAll(filepath.Dir(path), 0755); e != nil {
+		return e
+	}
+
+	file, e := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if e != nil {
+		return e
+	}
+
+	r.current = &filePart{file: file, path: path}
+	r.parts = append(r.parts, r.current)
+
+	return nil
+}
+
+func (r *fileRun) finish(success bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.current.writer == nil && len(r.columns) > 0 && success {
+		if w, e := r.newWriter(r.current); e == nil {
+			r.current.writer = w
+			w.write(nil)
+		}
+	}
+
+	e := r.current.close()
+	for _, part := range r.parts {
+		if e == nil && success {
+			e = os.Rename(part.file.Name(), part.path)
+		} else {
+			os.Remove(part.file.Name())
+		}
+	}
+
+	return e
+}
+
+func (r *fileRun) newWriter(part *filePart) (rowWriter, error) {
+	var w io.Writer = part.file
+
+	if r.config.Format == FileFormatParquet {
+		codec, e := parquetCodec(r.config.Compression)
+		if e != nil {
+			return nil, e
+		}
+
+		return newParquetWriter(w, r.columns, r.kinds(), codec), nil
+	}
+
+	switch r.config.Compression {
+	case "", "none":
+	case "gzip":
+		gz := gzip.NewWriter(w)
+		part.closers = append(part.closers, gz)
+		w = gz
+	default:
+		return nil, fmt.Errorf("[target] unsupported compression: %s", r.config.Compression)
+	}
+
+	if r.config.Format == FileFormatJSONL {
+		return &jsonlWriter{w: w, columns: r.config.Columns}, nil
+	}
+
+	cw := csv.NewWriter(w)
+	if r.config.Delimiter != "" {
+		cw.Comma = []rune(r.config.Delimiter)[0]
+	}
+
+	return &csvWriter{w: cw, columns: r.columns}, nil
+}
+
+func (p *filePart) close() error {
+	var e error
+	if p.writer != nil {
+		e = p.writer.close()
+	}
+
+	for i := len(p.closers) - 1; i >= 0; i-- {
+		if err := p.closers[i].Close(); err != nil && e == nil {
+			e = err
+		}
+	}
+	p.closers = nil
+
+	if err := p.file.Close(); err != nil && e == nil && !errors.Is(err, os.ErrClosed) {
+		e = err
+	}
+
+	return e
+}
+
+type csvWriter struct {
+	w       *csv.Writer
+	columns []string
+	started bool
+}
+
+func (c *csvWriter) write(rows []map[string]any) error {
+	if !c.started {
+		c.started = true
+		if e := c.w.Write(c.columns); e != nil {
+			return e
+		}
+	}
+
+	record := make([]string, len(c.columns))
+	for _, row := range rows {
+		for i, column := range c.columns {
+			record[i] = ds.ValueString(row[column])
+		}
+
+		if e := c.w.Write(record); e != nil {
+			return e
+		}
+	}
+
+	return nil
+}
+
+func (c *csvWriter) close() error {
+	if c.w == nil {
+		return nil
+	}
+	c.w.Flush()
+
+	return c.w.Error()
+}
+
+type jsonlWriter struct {
+	w       io.Writer
+	columns []string
+}
+
+func (j *jsonlWriter) write(rows []map[string]any) error {
+	for _, row := range rows {
+		b, e := jsoniter.Marshal(ds.ProjectRow(row, j.columns))
+		if e != nil {
+			return e
+		}
+
+		if _, e := j.w.Write(append(b, '\n')); e != nil {
+			return e
+		}
+	}
+
+	return nil
+}
+
+func (j *jsonlWriter) close() error {
+	return nil
+}
+
+// parquetInferRows rows buffered at most to infer the kinds of columns without a known type
+const parquetInferRows = 10000
+
+// kinds the parquet kinds of the columns, from the mapped types or the kinds of previous parts
+func (r *fileRun) kinds() map[string]string {
+	for _, column := range r.mapped {
+		if _, ok := r.types[column.Name]; !ok {
+			if kind := parquetKind(column.Type); kind != "" {
+				r.types[column.Name] = kind
+			}
+		}
+	}
+
+	return r.types
+}
+
+// parquetWriter writes optional int64, double, boolean or string columns in the order of columns,
+// rows are buffered until a value of every column without a known kind is seen
+type parquetWriter struct {
+	out     io.Writer
+	codec   compress.Codec
+	columns []string
+	kinds   []string
+	types   map[string]string
+	pending []map[string]any
+	w       *parquet.Writer
+}
+
+func newParquetWriter(w io.Writer, columns []string, types map[string]string, codec compress.Codec) *parquetWriter {
+	kinds := make([]string, len(columns))
+	for i, column := range columns {
+		kinds[i] = types[column]
+	}
+
+	return &parquetWriter{out: w, codec: codec, columns: columns, kinds: kinds, types: types}
+}
+
+func (p *parquetWriter) write(rows []map[string]any) error {
+	if p.w == nil {
+		p.pending = append(p.pending, rows...)
+		if !p.infer(rows) && len(p.pending) < parquetInferRows {
+			return nil
+		}
+
+		rows = p.pending
+		p.pending = nil
+		p.open()
+	}
+
+	prows := make([]parquet.Row, len(rows))
+	for i, row := range rows {
+		prow := make(parquet.Row, len(p.columns))
+		for c, column := range p.columns {
+			v, e := parquetValue(p.kinds[c], row[column])
+			if e != nil {
+				return fmt.Errorf("[target] parquet column %s: %w", column, e)
+			}
+
+			if v.IsNull() {
+				prow[c] = v.Level(0, 0, c)
+			} else {
+				prow[c] = v.Level(0, 1, c)
+			}
+		}
+		prows[i] = prow
+	}
+
+	_, e := p.w.WriteRows(prows)
+
+	return e
+}
+
+// infer sets the kinds of unknown columns from the values of rows, reporting whether all are known
+func (p *parquetWriter) infer(rows []map[string]any) bool {
+	known := true
+	for i, column := range p.columns {
+		if p.kinds[i] != "" {
+			continue
+		}
+
+		for _, row := range rows {
+			if row[column] != nil {
+				p.kinds[i] = valueKind(row[column])
+				break
+			}
+		}
+
+		known = known && p.kinds[i] != ""
+	}
+
+	return known
+}
+
+// open creates the writer, columns still unknown are strings
+func (p *parquetWriter) open() {
+	group := parquet.Group{}
+	fields := make([]parquet.Field, len(p.columns))
+	for i, column := range p.columns {
+		if p.kinds[i] == "" {
+			p.kinds[i] = ds.TypeString
+		}
+		p.types[column] = p.kinds[i]
+
+		var node parquet.Node
+		switch p.kinds[i] {
+		case ds.TypeInt:
+			node = parquet.Optional(parquet.Int(64))
+		case ds.TypeFloat:
+			node = parquet.Optional(parquet.Leaf(parquet.DoubleType))
+		case ds.TypeBool:
+			node = parquet.Optional(parquet.Leaf(parquet.BooleanType))
+		default:
+			node = parquet.Optional(parquet.String())
+		}
+		group[column] = node
+		fields[i] = parquetField{Node: node, name: column}
+	}
+
+	options := []parquet.WriterOption{parquet.NewSchema("row", parquetGroup{Group: group, fields: fields})}
+	if p.codec != nil {
+		options = append(options, parquet.Compression(p.codec))
+	}
+
+	p.w = parquet.NewWriter(p.out, options...)
+}
+
+func (p *parquetWriter) close() error {
+	if p.w == nil {
+		rows := p.pending
+		p.pending = nil
+		p.open()
+		if e := p.write(rows); e != nil {
+			return e
+		}
+	}
+
+	return p.w.Close()
+}
+
+// parquetGroup a group keeping the order of its fields, parquet.Group orders them by name
+type parquetGroup struct {
+	parquet.Group
+	fields []parquet.Field
+}
+
+func (g parquetGroup) Fields() []parquet.Field {
+	return g.fields
+}
+
+type parquetField struct {
+	parquet.Node
+	name string
+}
+
+func (f parquetField) Name() string {
+	return f.name
+}
+
+func (f parquetField) Value(base reflect.Value) reflect.Value {
+	return base.MapIndex(reflect.ValueOf(f.name))
+}
+
+// parquetKind the parquet kind of a column type, empty when unknown
+func parquetKind(typ string) string {
+	switch typ {
+	case "":
+		return ""
+	case ds.TypeInt, ds.TypeFloat, ds.TypeBool:
+		return typ
+	}
+
+	return ds.TypeString
+}
+
+func valueKind(v any) string {
+	switch v.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return ds.TypeInt
+	case float32, float64:
+		return ds.TypeFloat
+	case bool:
+		return ds.TypeBool
+	}
+
+	return ds.TypeString
+}
+
+// parquetValue converts a value to the kind of its column, values which do not convert fail
+func parquetValue(kind string, v any) (parquet.Value, error) {
+	if v == nil {
+		return parquet.NullValue(), nil
+	}
+
+	switch kind {
+	case ds.TypeInt:
+		i, e := strconv.ParseInt(ds.ValueString(v), 10, 64)
+		if e != nil {
+			return parquet.Value{}, fmt.Errorf("%v is not an int", v)
+		}
+		return parquet.Int64Value(i), nil
+	case ds.TypeFloat:
+		fl, e := strconv.ParseFloat(ds.ValueString(v), 64)
+		if e != nil {
+			return parquet.Value{}, fmt.Errorf("%v is not a float", v)
+		}
+		return parquet.DoubleValue(fl), nil
+	case ds.TypeBool:
+		b, e := strconv.ParseBool(ds.ValueString(v))
+		if e != nil {
+			return parquet.Value{}, fmt.Errorf("%v is not a bool", v)
+		}
+		return parquet.BooleanValue(b), nil
+	}
+
+	return parquet.ByteArrayValue([]byte(ds.ValueString(v))), nil
+}
+
+func parquetCodec(name string) (compress.Codec, error) {
+	switch name {
+	case "", "none":
+		return nil, nil
+	case "snappy":
+		return &snappy.Codec{}, nil
+	case "gzip":
+		return &pgzip.Codec{}, nil
+	case "zstd":
+		return &zstd.Codec{}, nil
+	}
+
+	return nil, fmt.Errorf("[target] unsupported parquet compression: %s", name)
+}
+
+func fileFormat(path string) string {
+	name := strings.TrimSuffix(strings.ToLower(path), ".gz")
+
+	switch filepath.Ext(name) {
+	case ".jsonl", ".ndjson", ".json":
+		return FileFormatJSONL
+	case ".parquet":
+		return FileFormatParquet
+	}
+
+	return FileFormatCSV
+}
+
+// partPath inserts the part number before the first extension: users.csv.gz -> users-0001.csv.gz
+func partPath(path string, part int) string {
+	dir, base := filepath.Split(path)
+	name, ext := base, ""
+	if i := strings.Index(base, "."); i > 0 {
+		name, ext = base[:i], base[i:]
+	}
+
+	return fmt.Sprintf("%s%s-%04d%s", dir, name, part, ext)
+}
+
+// runColumns the mapped columns in order, followed by the other fields of the rows by name
+func runColumns(mapped []ds.Column, data []map[string]any) []string {
+	seen := make(map[string]bool)
+	var columns, extra []string
+	for _, column := range mapped {
+		if !seen[column.Name] {
+			seen[column.Name] = true
+			columns = append(columns, column.Name)
+		}
+	}
+
+	for _, row := range data {
+		for k := range row {
+			if !seen[k] {
+				seen[k] = true
+				extra = append(extra, k)
+			}
+		}
+	}
+	sort.Strings(extra)
+
+	return append(columns, extra...)
+}
+
+func NewFileTarget() *FileTarget {
+	return &FileTarget{runs: make(map[*SyncMeta]*fileRun)}
+}
@@ -0,0 +1,161 @@
+package syncer_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/enorith/syncer"
+	"github.com/enorith/syncer/ds"
+	"github.com/parquet-go/parquet-go"
+)
+
+func runFileTarget(t *testing.T, conf syncer.TargetConfig, status int, batches ...[]map[string]any) {
+	target := syncer.NewFileTarget()
+	meta := &syncer.SyncMeta{TaskID: "export_users", Version: 3}
+
+	if e := target.BeforeSync(conf, meta); e != nil {
+		t.Fatal(e)
+	}
+
+	for _, batch := range batches {
+		if e := target.SyncFrom(conf, batch, meta); e != nil {
+			t.Fatal(e)
+		}
+	}
+
+	meta.Status = status
+	if e := target.AfterSync(conf, meta); e != nil {
+		t.Fatal(e)
+	}
+}
+
+func TestFileTargetCSV(t *testing.T) {
+	dir := t.TempDir()
+	conf := targetConfig(t, map[string]any{
+		"path":        filepath.Join(dir, "{{.TaskID}}", "v{{.Version}}.csv"),
+		"columns":     []string{"id", "name"},
+		"rotate_rows": 2,
+	})
+
+	runFileTarget(t, conf, syncer.SyncStatusSuccess,
+		[]map[string]any{{"id": 1, "name": "a"}, {"id": 2, "name": "b"}, {"id": 3, "name": "c"}},
+		[]map[string]any{{"id": 4, "name": "d", "ignored": true}},
+	)
+
+	first, e := os.ReadFile(filepath.Join(dir, "export_users", "v3-0001.csv"))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if string(first) != "id,name\n1,a\n2,b\n" {
+		t.Fatalf("unexpected first part: %q", first)
+	}
+
+	second, e := os.ReadFile(filepath.Join(dir, "export_users", "v3-0002.csv"))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if string(second) != "id,name\n3,c\n4,d\n" {
+		t.Fatalf("unexpected second part: %q", second)
+	}
+
+	failed := targetConfig(t, map[string]any{"path": filepath.Join(dir, "failed.jsonl")})
+	runFileTarget(t, failed, syncer.SyncStatusFailed, []map[string]any{{"id": 1}})
+
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") || entry.Name() == "failed.jsonl" {
+			t.Fatalf("failed run left %s behind", entry.Name())
+		}
+	}
+}
+
+func TestFileTargetParquet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.parquet")
+	conf := targetConfig(t, map[string]any{
+		"path":        path,
+		"compression": "snappy",
+	})
+
+	runFileTarget(t, conf, syncer.SyncStatusSuccess,
+		[]map[string]any{{"id": int64(1), "name": "a", "score": 1.5}, {"id": int64(2), "name": nil, "score": 2.0}},
+	)
+
+	f, e := os.Open(path)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer f.Close()
+
+	info, _ := f.Stat()
+	pf, e := parquet.OpenFile(f, info.Size())
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if pf.NumRows() != 2 {
+		t.Fatalf("expected 2 rows, got %d", pf.NumRows())
+	}
+
+	if id, ok := pf.Schema().Lookup("id"); !ok || id.Node.Type().Kind() != parquet.Int64 {
+		t.Fatalf("expected int64 id column: %v", pf.Schema())
+	}
+}
+
+func TestFileTargetParquetColumns(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.parquet")
+	conf := targetConfig(t, map[string]any{"path": path, "columns": []string{"name", "id", "score"}})
+
+	// score is null in the first batch, its kind comes from the second
+	runFileTarget(t, conf, syncer.SyncStatusSuccess,
+		[]map[string]any{{"id": int64(1), "name": "a", "score": nil}},
+		[]map[string]any{{"id": int64(2), "name": "b", "score": 2.5}},
+	)
+
+	f, e := os.Open(path)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer f.Close()
+
+	info, _ := f.Stat()
+	pf, e := parquet.OpenFile(f, info.Size())
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	var names []string
+	for _, field := range pf.Schema().Fields() {
+		names = append(names, field.Name())
+	}
+	if strings.Join(names, ",") != "name,id,score" {
+		t.Fatalf("expected configured column order, got %v", names)
+	}
+
+	if score, ok := pf.Schema().Lookup("score"); !ok || score.Node.Type().Kind() != parquet.Double {
+		t.Fatalf("expected double score column: %v", pf.Schema())
+	}
+
+	rows := make([]parquet.Row, 2)
+	reader := parquet.NewReader(pf)
+	if n, _ := reader.ReadRows(rows); n != 2 {
+		t.Fatalf("expected 2 rows, got %d", n)
+	}
+	if rows[1][0].String() != "b" || rows[1][1].Int64() != 2 || rows[1][2].Double() != 2.5 || !rows[0][2].IsNull() {
+		t.Fatalf("unexpected rows: %v", rows)
+	}
+
+	mismatch := targetConfig(t, map[string]any{"path": filepath.Join(t.TempDir(), "users.parquet")})
+	target := syncer.NewFileTarget()
+	meta := &syncer.SyncMeta{TaskID: "export_users", Columns: []ds.Column{{Name: "id", Type: ds.TypeInt}}}
+	if e := target.BeforeSync(mismatch, meta); e != nil {
+		t.Fatal(e)
+	}
+	e = target.SyncFrom(mismatch, []map[string]any{{"id": 1.5}}, meta)
+	if e == nil || !strings.Contains(e.Error(), "1.5 is not an int") {
+		t.Fatalf("expected int conversion error, got %v", e)
+	}
+	meta.Status = syncer.SyncStatusFailed
+	target.AfterSync(mismatch, meta)
+}
@@ -0,0 +1,205 @@
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// checkDependencies finds a dependency on an unknown task or a dependency cycle between tasks
+func checkDependencies(tasks map[string]SyncerTask) error {
+	const (
+		visiting = iota + 1
+		visited
+	)
+
+	ids := make([]string, 0, len(tasks))
+	for id := range tasks {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		for _, dep := range tasks[id].DependsOn {
+			if _, ok := tasks[dep]; !ok {
+				return fmt.Errorf("[syncer] task %s depends on unknown task %s", id, dep)
+			}
+		}
+	}
+
+	states := make(map[string]int, len(tasks))
+	var path []string
+
+	var visit func(id string) error
+	visit = func(id string) error {
+		switch states[id] {
+		case visiting:
+			start := 0
+			for i, p := range path {
+				if p == id {
+					start = i
+				}
+			}
+			return fmt.Errorf("[syncer] dependency cycle: %s -> %s", strings.Join(path[start:], " -> "), id)
+		case visited:
+			return nil
+		}
+
+		states[id] = visiting
+		path = append(path, id)
+		for _, dep := range tasks[id].DependsOn {
+			if e := visit(dep); e != nil {
+				return e
+			}
+		}
+		path = path[:len(path)-1]
+		states[id] = visited
+
+		return nil
+	}
+
+	for _, id := range ids {
+		if e := visit(id); e != nil {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// RunGraph runs the root tasks and every task depending on them, all tasks without roots. A task starts
+// once its dependencies in the graph succeeded and independent tasks run in parallel, tasks downstream
+// of a failure are skipped. The meta of each task is returned with the failures joined
+func (s *Syncer) RunGraph(rootIDs ...string) (map[string]*SyncMeta, error) {
+	s.mu.RLock()
+	tasks := make(map[string]SyncerTask, len(s.tasks))
+	for id, task := range s.tasks {
+		tasks[id] = task
+	}
+	s.mu.RUnlock()
+
+	// tasks added by AddTask are not checked when added
+	if e := checkDependencies(tasks); e != nil {
+		return nil, e
+	}
+
+	for _, id := range rootIDs {
+		if _, ok := tasks[id]; !ok {
+			return nil, fmt.Errorf("[syncer] task not found: %s", id)
+		}
+	}
+
+	graph := graphTasks(tasks, rootIDs)
+
+	var (
+		mu    sync.Mutex
+		metas = make(map[string]*SyncMeta, len(graph))
+		errs  []error
+		wg    sync.WaitGroup
+		done  = make(map[string]chan struct{}, len(graph))
+	)
+	for id := range graph {
+		done[id] = make(chan struct{})
+	}
+
+	for id := range graph {
+		wg.Add(1)
+		go func(task SyncerTask) {
+			defer wg.Done()
+			defer close(done[task.ID])
+
+			var failed []string
+			for _, dep := range task.DependsOn {
+				ch, ok := done[dep]
+				if !ok {
+					continue
+				}
+				<-ch
+
+				mu.Lock()
+				if metas[dep].Status != SyncStatusSuccess {
+					failed = append(failed, dep)
+				}
+				mu.Unlock()
+			}
+
+			var (
+				meta *SyncMeta
+				e    error
+			)
+			if len(failed) > 0 {
+				meta = &SyncMeta{TaskID: task.ID, Status: SyncStatusSkipped}
+				e = fmt.Errorf("[syncer] task %s skipped, upstream %s not succeeded", task.ID, strings.Join(failed, ", "))
+			} else {
+				meta, e = s.RunTask(task)
+				if e != nil {
+					meta.Status = SyncStatusFailed
+					meta.Error = e
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			metas[task.ID] = meta
+			if e != nil {
+				errs = append(errs, e)
+			}
+		}(graph[id])
+	}
+
+	wg.Wait()
+
+	return metas, errors.Join(errs...)
+}
+
+// scheduledUpstream the first upstream task of a task which has an interval, its graph runs the task
+func scheduledUpstream(tasks map[string]SyncerTask, id string) (string, bool) {
+	seen := make(map[string]bool)
+	queue := append([]string{}, tasks[id].DependsOn...)
+	for len(queue) > 0 {
+		dep := queue[0]
+		queue = queue[1:]
+		if seen[dep] {
+			continue
+		}
+		seen[dep] = true
+
+		if tasks[dep].Interval != "" {
+			return dep, true
+		}
+		queue = append(queue, tasks[dep].DependsOn...)
+	}
+
+	return "", false
+}
+
+// graphTasks the roots and their transitive dependents, every task without roots
+func graphTasks(tasks map[string]SyncerTask, rootIDs []string) map[string]SyncerTask {
+	if len(rootIDs) == 0 {
+		return tasks
+	}
+
+	dependents := make(map[string][]string)
+	for id, task := range tasks {
+		for _, dep := range task.DependsOn {
+			dependents[dep] = append(dependents[dep], id)
+		}
+	}
+
+	graph := make(map[string]SyncerTask)
+	queue := append([]string{}, rootIDs...)
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if _, ok := graph[id]; ok {
+			continue
+		}
+
+		graph[id] = tasks[id]
+		queue = append(queue, dependents[id]...)
+	}
+
+	return graph
+}
@@ -0,0 +1,252 @@
+package syncer
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/enorith/syncer/ds"
+	jsoniter "github.com/json-iterator/go"
+)
+
+const (
+	HTTPFormatJSON   = "json"
+	HTTPFormatNDJSON = "ndjson"
+
+	DefaultSignatureHeader = "X-Syncer-Signature"
+	TimestampHeader        = "X-Syncer-Timestamp"
+)
+
+// HTTPTargetConfig header values and secret are expanded with environment variables,
+// with a secret, requests carry the signature header "sha256=" + hex(hmac_sha256(secret, timestamp + "." + body))
+// and the timestamp header
+type HTTPTargetConfig struct {
+	URL             string            `json:"url"`
+	Method          string            `json:"method"`
+	Format          string            `json:"format"`
+	Headers         map[string]string `json:"headers"`
+	Secret          string            `json:"secret"`
+	SignatureHeader string            `json:"signature_header"`
+	BatchSize       int               `json:"batch_size"`
+	MaxRetries      *int              `json:"max_retries"`
+	Timeout         string            `json:"timeout"`
+
+	// BeforeURL and AfterURL receive the sync meta as json
+	BeforeURL string `json:"before_url"`
+	AfterURL  string `json:"after_url"`
+}
+
+type HTTPSyncEvent struct {
+	Event   string `json:"event"`
+	TaskID  string `json:"task_id"`
+	Version int    `json:"version"`
+	Total   int64  `json:"total"`
+	Status  int    `json:"status"`
+	Error   string `json:"error,omitempty"`
+}
+
+// HTTPTarget pushes each synced batch to a webhook
+type HTTPTarget struct {
+	client *http.Client
+}
+
+func (ht *HTTPTarget) SyncFrom(conf TargetConfig, data []map[string]any, meta *SyncMeta) error {
+	config, e := ht.config(conf)
+	if e != nil {
+		return e
+	}
+
+	if config.URL == "" {
+		return errors.New("[target] http url is required")
+	}
+
+	size := config.BatchSize
+	if size <= 0 {
+		size = len(data)
+	}
+
+	for start := 0; start < len(data); start += size {
+		batch := data[start:min(start+size, len(data))]
+
+		body, contentType, e := encodeBatch(config.Format, batch)
+		if e != nil {
+			return e
+		}
+
+		if e := ht.send(config, config.Method, config.URL, contentType, body); e != nil {
+			return e
+		}
+	}
+
+	return nil
+}
+
+func (ht *HTTPTarget) BeforeSync(conf TargetConfig, meta *SyncMeta) error {
+	config, e := ht.config(conf)
+	if e != nil || config.BeforeURL == "" {
+		return e
+	}
+
+	return ht.notify(config, config.BeforeURL, "before", meta)
+}
+
+func (ht *HTTPTarget) AfterSync(conf TargetConfig, meta *SyncMeta) error {
+	config, e := ht.config(conf)
+	if e != nil || config.AfterURL == "" {
+		return e
+	}
+
+	return ht.notify(config, config.AfterURL, "after", meta)
+}
+
+func (ht *HTTPTarget) notify(config HTTPTargetConfig, url, event string, meta *SyncMeta) error {
+	payload := HTTPSyncEvent{
+		Event:   event,
+		TaskID:  meta.TaskID,
+		Version: meta.Version,
+		Total:   meta.Total,
+		Status:  meta.Status,
+	}
+	if meta.Error != nil {
+		payload.Error = meta.Error.Error()
+	}
+
+	body, e := jsoniter.Marshal(payload)
+	if e != nil {
+		return e
+	}
+
+	return ht.send(config, http.MethodPost, url, "application/json", body)
+}
+
+// send retries 5xx and 429 responses, honoring Retry-After
+func (ht *HTTPTarget) send(config HTTPTargetConfig, method, url, contentType string, body []byte) error {
+	retries := 3
+	if config.MaxRetries != nil {
+		retries = *config.MaxRetries
+	}
+
+	client := ht.client
+	if config.Timeout != "" {
+		timeout, e := time.ParseDuration(config.Timeout)
+		if e != nil {
+			return e
+		}
+		c := *client
+		c.Timeout = timeout
+		client = &c
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, e := http.NewRequest(method, url, bytes.NewReader(body))
+		if e != nil {
+			return e
+		}
+
+		req.Header.Set("Content-Type", contentType)
+		for k, v := range config.Headers {
+			req.Header.Set(k, os.ExpandEnv(v))
+		}
+
+		if config.Secret != "" {
+			timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+			req.Header.Set(TimestampHeader, timestamp)
+			req.Header.Set(config.SignatureHeader, "sha256="+SignPayload(os.ExpandEnv(config.Secret), timestamp, body))
+		}
+
+		resp, e := client.Do(req)
+		if e != nil {
+			return e
+		}
+
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		if retryable && attempt < retries {
+			<-time.After(ds.RetryAfter(resp.Header, attempt))
+			continue
+		}
+
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("[target] http %s %s: %s", method, url, resp.Status)
+		}
+
+		return nil
+	}
+}
+
+func (ht *HTTPTarget) ValidateConfig(conf TargetConfig) error {
+	_, e := ht.config(conf)
+
+	return e
+}
+
+func (ht *HTTPTarget) config(conf TargetConfig) (HTTPTargetConfig, error) {
+	var config HTTPTargetConfig
+	if e := conf.Unmarshal(&config); e != nil {
+		return config, e
+	}
+
+	if config.Method == "" {
+		config.Method = http.MethodPost
+	}
+
+	if config.Format == "" {
+		config.Format = HTTPFormatJSON
+	}
+
+	if config.SignatureHeader == "" {
+		config.SignatureHeader = DefaultSignatureHeader
+	}
+
+	return config, nil
+}
+
+// SignPayload signs a webhook body, receivers recompute it from the timestamp header and raw body
+// and compare it to the signature header without its "sha256=" prefix
+func SignPayload(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func encodeBatch(format string, batch []map[string]any) ([]byte, string, error) {
+	switch format {
+	case HTTPFormatJSON:
+		body, e := jsoniter.Marshal(batch)
+		return body, "application/json", e
+	case HTTPFormatNDJSON:
+		var buf bytes.Buffer
+		for _, row := range batch {
+			b, e := jsoniter.Marshal(row)
+			if e != nil {
+				return nil, "", e
+			}
+			buf.Write(b)
+			buf.WriteByte('\n')
+		}
+		return buf.Bytes(), "application/x-ndjson", nil
+	}
+
+	return nil, "", fmt.Errorf("[target] unsupported http format: %s", format)
+}
+
+func NewHTTPTarget(client *http.Client) *HTTPTarget {
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	return &HTTPTarget{client: client}
+}
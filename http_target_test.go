@@ -0,0 +1,94 @@
+package syncer_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/enorith/syncer"
+	jsoniter "github.com/json-iterator/go"
+)
+
+func TestHTTPTarget(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		batches [][]byte
+		events  []syncer.HTTPSyncEvent
+		failed  bool
+	)
+
+	t.Setenv("WEBHOOK_SECRET", "s3cret")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rows", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if !failed {
+			failed = true
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		sign := "sha256=" + syncer.SignPayload("s3cret", r.Header.Get(syncer.TimestampHeader), body)
+		if r.Header.Get(syncer.DefaultSignatureHeader) != sign || r.Header.Get("Content-Type") != "application/x-ndjson" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		batches = append(batches, body)
+	})
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		var event syncer.HTTPSyncEvent
+		jsoniter.NewDecoder(r.Body).Decode(&event)
+		events = append(events, event)
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	target := syncer.NewHTTPTarget(nil)
+	conf := targetConfig(t, map[string]any{
+		"url":        server.URL + "/rows",
+		"format":     "ndjson",
+		"secret":     "${WEBHOOK_SECRET}",
+		"batch_size": 2,
+		"before_url": server.URL + "/events",
+		"after_url":  server.URL + "/events",
+	})
+
+	meta := &syncer.SyncMeta{TaskID: "push_users", Version: 2, Total: 3}
+	if e := target.BeforeSync(conf, meta); e != nil {
+		t.Fatal(e)
+	}
+
+	if e := target.SyncFrom(conf, []map[string]any{{"id": 1}, {"id": 2}, {"id": 3}}, meta); e != nil {
+		t.Fatal(e)
+	}
+
+	meta.Status = syncer.SyncStatusSuccess
+	if e := target.AfterSync(conf, meta); e != nil {
+		t.Fatal(e)
+	}
+
+	if len(batches) != 2 || !bytes.Equal(batches[1], []byte("{\"id\":3}\n")) {
+		t.Fatalf("unexpected batches: %q", batches)
+	}
+
+	if len(events) != 2 || events[0].Event != "before" || events[1].Status != syncer.SyncStatusSuccess || events[1].TaskID != "push_users" {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+
+	badConf := targetConfig(t, map[string]any{"url": server.URL + "/missing", "max_retries": 0})
+	if e := target.SyncFrom(badConf, []map[string]any{{"id": 1}}, meta); e == nil {
+		t.Fatal("expected error for 404 response")
+	}
+}
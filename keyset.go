@@ -0,0 +1,64 @@
+package syncer
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/enorith/syncer/ds"
+)
+
+// keysetKeys the primary keys of the source pages are read after, nil without keyset
+func (task SyncerTask) keysetKeys(source ds.Datasource, agg *aggregatePlan) ([]string, error) {
+	if !task.Keyset {
+		return nil, nil
+	}
+
+	provider, ok := source.(ds.KeyProvider)
+	if !ok {
+		return nil, fmt.Errorf("[syncer] keyset of task %s requires a source with primary keys", task.ID)
+	}
+
+	if len(task.Orders) > 0 {
+		return nil, errors.New("[syncer] keyset pages are read in primary key order, orders are not supported")
+	}
+
+	if agg != nil && agg.pushdown {
+		return nil, errors.New("[syncer] keyset can not page aggregated groups")
+	}
+
+	return provider.PrimaryKeys(), nil
+}
+
+// readKeyset lists the pages of opt after the keys of the last row of the previous page until
+// a short page, or fn returns false
+func readKeyset(source ds.Datasource, opt ds.ListOption, keys []string, fn func(page int64, data []any) bool) error {
+	opt.Orders = make([]ds.ListOrder, len(keys))
+	for i, key := range keys {
+		opt.Orders[i] = ds.ListOrder{Field: key, Order: "asc"}
+	}
+
+	for page := int64(1); ; page++ {
+		data, e := source.List(opt)
+		if e != nil {
+			return e
+		}
+
+		if len(data.Data) == 0 {
+			return nil
+		}
+
+		if !fn(page, data.Data) || int64(len(data.Data)) < opt.Limit {
+			return nil
+		}
+
+		last, ok := data.Data[len(data.Data)-1].(map[string]any)
+		if !ok {
+			return errors.New("[syncer] keyset requires map rows")
+		}
+
+		opt.After = make(map[string]any, len(keys))
+		for _, key := range keys {
+			opt.After[key] = last[key]
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package syncer_test
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/enorith/syncer/ds"
+)
+
+// keysetSource a file source paged by its id key
+type keysetSource struct {
+	ds.Datasource
+	afters *[]any
+}
+
+func (k keysetSource) PrimaryKeys() []string {
+	return []string{"id"}
+}
+
+func (k keysetSource) List(opt ds.ListOption) (ds.ListResult, error) {
+	if opt.After != nil {
+		*k.afters = append(*k.afters, opt.After["id"])
+		opt.Filters = append(opt.Filters, ds.ListFilter{Field: "id", Op: ">", Value: opt.After["id"]})
+	}
+
+	return k.Datasource.List(opt)
+}
+
+func TestKeyset(t *testing.T) {
+	dir := fileFixture(t, map[string]string{
+		"items.csv": "id,name\n1,a\n2,b\n3,c\n4,d\n5,e\n",
+	})
+
+	var afters []any
+	ds.RegisterDatasource("keysetcsv", func(u *url.URL) (ds.Datasource, error) {
+		u.Scheme = "csv"
+		source, e := ds.FileRegister(u)
+		return keysetSource{Datasource: source, afters: &afters}, e
+	})
+
+	sy := loadTasks(t, fmt.Sprintf(`[{
+		"id": "items",
+		"source": "keysetcsv://%[1]s/items.csv",
+		"mapping": {"id": "id", "name": "name"},
+		"target": "jsonl://%[1]s/items.jsonl",
+		"keyset": true,
+		"size": 2,
+		"workers": 2
+	}]`, dir))
+
+	if _, e := sy.DoSync("items"); e != nil {
+		t.Fatal(e)
+	}
+
+	if fmt.Sprint(afters) != "[2 4]" {
+		t.Fatalf("unexpected keyset pages: %v", afters)
+	}
+
+	target, _ := ds.Connect("jsonl://" + dir + "/items.jsonl")
+	meta, _ := target.ListMeta()
+	if meta.Total != 5 {
+		t.Fatalf("expected 5 rows, got %d", meta.Total)
+	}
+}
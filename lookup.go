@@ -0,0 +1,220 @@
+package syncer
+
+import (
+	"encoding/csv"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/enorith/syncer/ds"
+	jsoniter "github.com/json-iterator/go"
+)
+
+const (
+	LookupMissingNull  = "null"
+	LookupMissingKeep  = "keep"
+	LookupMissingError = "error"
+)
+
+var (
+	// LookupCacheSize keys cached by each lookup of a run
+	LookupCacheSize = 10000
+	// LookupBatchSize keys of one IN query
+	LookupBatchSize = 1000
+)
+
+type lookupArgs struct {
+	url, key, value, missing string
+}
+
+// lookupMatch a cached lookup result, misses are cached too
+type lookupMatch struct {
+	value any
+	found bool
+}
+
+// LookupResolver resolves a value through another datasource, lookup:url,key,value[,missing],
+// such as dept_id|lookup:db://remote/depts,id,name. urls with commas are quoted, as in
+// lookup:"csv://depts.csv?columns=id,name",id,name. missing is null (default), keep for the
+// original value, error to fail the page, or any other text used as the value. nil values
+// resolve to nil whatever missing is, there is no key to look up
+type LookupResolver struct {
+	mu      sync.Mutex
+	sources map[string]ds.Datasource
+	caches  map[lookupArgs]*lruCache
+}
+
+func (l *LookupResolver) Prefetch(values []any, args ...string) error {
+	la, e := parseLookupArgs(args)
+	if e != nil {
+		return e
+	}
+
+	cache := l.cache(la)
+	seen := make(map[string]bool)
+	var keys []any
+	for _, value := range values {
+		if value == nil {
+			continue
+		}
+
+		k := ds.ValueString(value)
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+
+		if _, ok := cache.get(k); !ok {
+			keys = append(keys, value)
+		}
+	}
+
+	return l.fetch(la, cache, keys)
+}
+
+func (l *LookupResolver) Resolve(value any, item map[string]any, args ...string) (any, error) {
+	la, e := parseLookupArgs(args)
+	if e != nil || value == nil {
+		return nil, e
+	}
+
+	cache := l.cache(la)
+	k := ds.ValueString(value)
+	cached, ok := cache.get(k)
+	if !ok {
+		if e := l.fetch(la, cache, []any{value}); e != nil {
+			return nil, e
+		}
+		cached, _ = cache.get(k)
+	}
+
+	if match, _ := cached.(lookupMatch); match.found {
+		return match.value, nil
+	}
+
+	switch la.missing {
+	case "", LookupMissingNull:
+		return nil, nil
+	case LookupMissingKeep:
+		return value, nil
+	case LookupMissingError:
+		return nil, fmt.Errorf("[syncer] lookup %s: no %s = %v", la.url, la.key, value)
+	}
+
+	return la.missing, nil
+}
+
+// fetch loads keys with one IN query per batch and caches them, found or not
+func (l *LookupResolver) fetch(la lookupArgs, cache *lruCache, keys []any) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	source, e := l.source(la.url)
+	if e != nil {
+		return e
+	}
+
+	for start := 0; start < len(keys); start += LookupBatchSize {
+		batch := keys[start:min(start+LookupBatchSize, len(keys))]
+
+		res, e := source.List(ds.ListOption{
+			WithoutMeta: true,
+			Selects:     []string{la.key, la.value},
+			Filters:     []ds.ListFilter{{Field: la.key, Op: "in", Value: batch}},
+		})
+		if e != nil {
+			return e
+		}
+
+		found := make(map[string]any, len(res.Data))
+		for _, data := range res.Data {
+			row, e := lookupRow(data)
+			if e != nil {
+				return e
+			}
+			found[ds.ValueString(row[la.key])] = row[la.value]
+		}
+
+		for _, key := range batch {
+			k := ds.ValueString(key)
+			value, ok := found[k]
+			cache.add(k, lookupMatch{value: value, found: ok})
+		}
+	}
+
+	return nil
+}
+
+func (l *LookupResolver) source(url string) (ds.Datasource, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if source, ok := l.sources[url]; ok {
+		return source, nil
+	}
+
+	source, e := ds.Connect(url)
+	if e != nil {
+		return nil, e
+	}
+	l.sources[url] = source
+
+	return source, nil
+}
+
+func (l *LookupResolver) cache(la lookupArgs) *lruCache {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	la.missing = ""
+	cache, ok := l.caches[la]
+	if !ok {
+		cache = newLRUCache(LookupCacheSize)
+		l.caches[la] = cache
+	}
+
+	return cache
+}
+
+// parseLookupArgs rejoins the args split by the mapping, so a quoted url keeps its commas
+func parseLookupArgs(args []string) (lookupArgs, error) {
+	if len(args) > 0 && strings.HasPrefix(args[0], `"`) {
+		fields, e := csv.NewReader(strings.NewReader(strings.Join(args, ","))).Read()
+		if e != nil {
+			return lookupArgs{}, fmt.Errorf("[syncer] lookup args %s: %w", strings.Join(args, ","), e)
+		}
+		args = fields
+	}
+
+	if len(args) < 3 {
+		return lookupArgs{}, fmt.Errorf("[syncer] lookup requires url,key,value, got %s", strings.Join(args, ","))
+	}
+
+	la := lookupArgs{url: args[0], key: args[1], value: args[2]}
+	if len(args) > 3 {
+		la.missing = args[3]
+	}
+
+	return la, nil
+}
+
+// lookupRow rows of models which are not maps are converted through json
+func lookupRow(data any) (map[string]any, error) {
+	if row, ok := data.(map[string]any); ok {
+		return row, nil
+	}
+
+	b, e := jsoniter.Marshal(data)
+	if e != nil {
+		return nil, e
+	}
+
+	var row map[string]any
+	return row, jsoniter.Unmarshal(b, &row)
+}
+
+// NewLookupResolver a lookup resolver with its own connections and caches, registered as lookup per run
+func NewLookupResolver() RunResolver {
+	return &LookupResolver{sources: make(map[string]ds.Datasource), caches: make(map[lookupArgs]*lruCache)}
+}
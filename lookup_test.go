@@ -0,0 +1,79 @@
+package syncer_test
+
+import (
+	"fmt"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/enorith/syncer"
+	"github.com/enorith/syncer/ds"
+)
+
+type countingSource struct {
+	ds.Datasource
+	lists *int64
+}
+
+func (c countingSource) List(opt ds.ListOption) (ds.ListResult, error) {
+	atomic.AddInt64(c.lists, 1)
+	return c.Datasource.List(opt)
+}
+
+func TestLookupResolver(t *testing.T) {
+	dir := fileFixture(t, map[string]string{
+		"users.csv": "id,dept_id\n1,10\n2,20\n3,10\n4,30\n",
+		"depts.csv": "id,name\n10,sales\n20,ops\n",
+		"raw.csv":   "10,sales\n20,ops\n",
+	})
+
+	var lists int64
+	ds.RegisterDatasource("countcsv", func(u *url.URL) (ds.Datasource, error) {
+		u.Scheme = "csv"
+		source, e := ds.FileRegister(u)
+		return countingSource{Datasource: source, lists: &lists}, e
+	})
+
+	mapping := syncer.ParseMapping(map[string]string{
+		"id":      "id",
+		"dept_id": fmt.Sprintf("dept|lookup:countcsv://%s/depts.csv,id,name,unknown", dir),
+	})
+
+	source, _ := ds.Connect("csv://" + dir + "/users.csv")
+	res, _ := source.List(ds.ListOption{})
+	var rows []map[string]any
+	for _, data := range res.Data {
+		rows = append(rows, data.(map[string]any))
+	}
+
+	run := mapping.NewRun()
+	items, e := run.ApplyPage(rows)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	depts := []any{items[0]["dept"], items[1]["dept"], items[2]["dept"], items[3]["dept"]}
+	if fmt.Sprint(depts) != "[sales ops sales unknown]" {
+		t.Fatalf("unexpected lookups: %v", depts)
+	}
+
+	if _, e := run.ApplyPage(rows); e != nil || lists != 1 {
+		t.Fatalf("expected one cached lookup query, got %d %v", lists, e)
+	}
+
+	quoted := syncer.ParseMapping(map[string]string{
+		"dept_id": fmt.Sprintf(`dept|lookup:"csv://%s/raw.csv?header=false&columns=id,name",id,name,keep`, dir),
+	})
+	items, e = quoted.NewRun().ApplyPage(append(rows, map[string]any{"id": 5}))
+	if e != nil {
+		t.Fatal(e)
+	}
+	if depts := []any{items[0]["dept"], items[3]["dept"], items[4]["dept"]}; fmt.Sprint(depts) != "[sales 30 <nil>]" {
+		t.Fatalf("unexpected lookups of a quoted url: %v", depts)
+	}
+
+	strict := syncer.ParseMapping(map[string]string{"dept_id": fmt.Sprintf("dept|lookup:countcsv://%s/depts.csv,id,name,error", dir)})
+	if _, e := strict.NewRun().ApplyPage(rows); e == nil {
+		t.Fatal("expected error for the missing dept")
+	}
+}
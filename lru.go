@@ -0,0 +1,58 @@
+package syncer
+
+import (
+	"container/list"
+	"sync"
+)
+
+type lruEntry struct {
+	key   string
+	value any
+}
+
+// lruCache a size bounded cache evicting the least recently used key
+type lruCache struct {
+	mu    sync.Mutex
+	size  int
+	items map[string]*list.Element
+	order *list.List
+}
+
+func (c *lruCache) get(key string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+
+	return el.Value.(*lruEntry).value, true
+}
+
+func (c *lruCache) add(key string, value any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		el.Value.(*lruEntry).value = value
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&lruEntry{key: key, value: value})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry).key)
+	}
+}
+
+func newLRUCache(size int) *lruCache {
+	if size < 1 {
+		size = 1
+	}
+
+	return &lruCache{size: size, items: make(map[string]*list.Element), order: list.New()}
+}
@@ -0,0 +1,215 @@
+package syncer
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/enorith/syncer/ds"
+)
+
+// ResolverCall a resolver of a mapping with its arguments, such as int or default:0
+type ResolverCall struct {
+	Name string
+	Args []string
+}
+
+// MappingStep writes a source field into a target column through resolvers, a mapping
+// "name": "name|trim;nickname" has two steps, the second continues from the value of the first
+type MappingStep struct {
+	Source    string
+	Column    string
+	Resolvers []ResolverCall
+	// Chained the step starts from the value of the previous step instead of the source field
+	Chained bool
+}
+
+// Mapping parsed task mapping, steps of a source field are kept together in order
+type Mapping []MappingStep
+
+// ParseMapping parses a task mapping of source field to "column|resolver:arg,arg;column..."
+func ParseMapping(mapping map[string]string) Mapping {
+	sources := make([]string, 0, len(mapping))
+	for source := range mapping {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	var steps Mapping
+	for _, source := range sources {
+		for i, part := range strings.Split(mapping[source], ";") {
+			resolvers := strings.Split(part, "|")
+			step := MappingStep{Source: source, Column: resolvers[0], Chained: i > 0}
+
+			for _, resolver := range resolvers[1:] {
+				partsParams := strings.SplitN(resolver, ":", 2)
+				call := ResolverCall{Name: resolver}
+				if len(partsParams) > 1 {
+					call.Name = partsParams[0]
+					call.Args = strings.Split(partsParams[1], ",")
+				}
+				step.Resolvers = append(step.Resolvers, call)
+			}
+
+			steps = append(steps, step)
+		}
+	}
+
+	return steps
+}
+
+// Apply maps a source row into a target row
+func (m Mapping) Apply(row map[string]any) map[string]any {
+	item := make(map[string]any, len(m))
+
+	var value any
+	for _, step := range m {
+		if !step.Chained {
+			value = row[step.Source]
+		}
+
+		for _, call := range step.Resolvers {
+			value = ResolveValue(value, row, call.Name, call.Args...)
+		}
+		item[step.Column] = value
+	}
+
+	return item
+}
+
+// MappingRun applies a mapping during one run, registered resolver factories are instantiated once
+// per run and resolvers implementing Prefetcher load each page in one go
+type MappingRun struct {
+	mapping   Mapping
+	mu        sync.Mutex
+	resolvers map[string]RunResolver
+}
+
+func (m Mapping) NewRun() *MappingRun {
+	return &MappingRun{mapping: m, resolvers: make(map[string]RunResolver)}
+}
+
+// ApplyPage maps the source rows of a page, resolving it step by step so prefetchers see every value
+func (r *MappingRun) ApplyPage(rows []map[string]any) ([]map[string]any, error) {
+	items := make([]map[string]any, len(rows))
+	for i := range items {
+		items[i] = make(map[string]any, len(r.mapping))
+	}
+
+	values := make([]any, len(rows))
+	for _, step := range r.mapping {
+		if !step.Chained {
+			for i, row := range rows {
+				values[i] = row[step.Source]
+			}
+		}
+
+		for _, call := range step.Resolvers {
+			resolver := r.resolver(call.Name)
+			if resolver == nil {
+				continue
+			}
+
+			if prefetcher, ok := resolver.(Prefetcher); ok {
+				if e := prefetcher.Prefetch(values, call.Args...); e != nil {
+					return nil, e
+				}
+			}
+
+			for i, row := range rows {
+				value, e := resolver.Resolve(values[i], row, call.Args...)
+				if e != nil {
+					return nil, e
+				}
+				values[i] = value
+			}
+		}
+
+		for i := range rows {
+			items[i][step.Column] = values[i]
+		}
+	}
+
+	return items, nil
+}
+
+func (r *MappingRun) resolver(name string) RunResolver {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	resolver, ok := r.resolvers[name]
+	if !ok {
+		resolver = newRunResolver(name)
+		r.resolvers[name] = resolver
+	}
+
+	return resolver
+}
+
+// Columns infers the target columns from the source schema and the resolver output types,
+// the type is empty when neither is known
+func (m Mapping) Columns(schema []ds.Column) []ds.Column {
+	sourceColumns := make(map[string]ds.Column, len(schema))
+	for _, column := range schema {
+		sourceColumns[column.Name] = column
+	}
+
+	var (
+		columns []ds.Column
+		index   = make(map[string]int)
+		current ds.Column
+	)
+	for _, step := range m {
+		if !step.Chained {
+			source := sourceColumns[step.Source]
+			current = ds.Column{Type: source.Type, Length: source.Length, Precision: source.Precision, Scale: source.Scale}
+		}
+
+		for _, call := range step.Resolvers {
+			if typ, ok := ResolverOutputType(call.Name); ok {
+				current = ds.Column{Type: typ}
+			}
+		}
+
+		column := ds.Column{Name: step.Column, Type: current.Type, Length: current.Length, Precision: current.Precision, Scale: current.Scale, Nullable: true}
+
+		if i, ok := index[step.Column]; ok {
+			columns[i] = column
+			continue
+		}
+
+		index[step.Column] = len(columns)
+		columns = append(columns, column)
+	}
+
+	return columns
+}
+
+// SourceFields the source fields the mapping reads, its sources and the fields its resolvers
+// declare reading, all when a resolver reads whole rows or does not declare its reads
+func (m Mapping) SourceFields() (fields []string, all bool) {
+	seen := make(map[string]bool)
+	add := func(field string) {
+		if !seen[field] {
+			seen[field] = true
+			fields = append(fields, field)
+		}
+	}
+
+	for _, step := range m {
+		add(step.Source)
+
+		for _, call := range step.Resolvers {
+			reads, row := resolverReads(call)
+			if row {
+				return nil, true
+			}
+
+			for _, field := range reads {
+				add(field)
+			}
+		}
+	}
+
+	return fields, false
+}
@@ -0,0 +1,73 @@
+package syncer_test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/enorith/syncer"
+	"github.com/enorith/syncer/ds"
+)
+
+func TestMapping(t *testing.T) {
+	mapping := syncer.ParseMapping(map[string]string{
+		"id":   "src_id",
+		"name": "name|trim;nickname",
+		"age":  "age|int",
+	})
+
+	row := mapping.Apply(map[string]any{"id": 1, "name": " nerio ", "age": "18"})
+	want := map[string]any{"src_id": 1, "name": "nerio", "nickname": "nerio", "age": int64(18)}
+	if !reflect.DeepEqual(row, want) {
+		t.Fatalf("unexpected row: %v", row)
+	}
+
+	columns := mapping.Columns([]ds.Column{
+		{Name: "id", Type: ds.TypeInt},
+		{Name: "name", Type: ds.TypeString, Length: 64},
+		{Name: "age", Type: ds.TypeString},
+	})
+
+	types := make(map[string]string)
+	for _, column := range columns {
+		types[column.Name] = column.Type
+	}
+
+	wantTypes := map[string]string{"src_id": ds.TypeInt, "name": ds.TypeString, "nickname": ds.TypeString, "age": ds.TypeInt}
+	if !reflect.DeepEqual(types, wantTypes) {
+		t.Fatalf("unexpected column types: %v", types)
+	}
+}
+
+func TestMappingSourceFields(t *testing.T) {
+	syncer.RegisterValueResolver("full_name", func(value any, item map[string]any, args ...string) any {
+		return fmt.Sprint(item["first_name"], " ", item["last_name"])
+	}, syncer.ResolverReads(func(args ...string) []string {
+		return []string{"first_name", "last_name"}
+	}))
+	syncer.RegisterValueResolver("row_json", func(value any, item map[string]any, args ...string) any {
+		return fmt.Sprint(item)
+	}, syncer.ResolverReadsRow())
+
+	fields, all := syncer.ParseMapping(map[string]string{
+		"id":         "src_id",
+		"first_name": "name|full_name",
+	}).SourceFields()
+	if all || fmt.Sprint(fields) != "[first_name last_name id]" {
+		t.Fatalf("unexpected source fields: %v %v", fields, all)
+	}
+
+	if _, all := syncer.ParseMapping(map[string]string{"id": "raw|row_json"}).SourceFields(); !all {
+		t.Fatal("expected a resolver reading rows to read all fields")
+	}
+
+	syncer.RegisterValueResolver("undeclared", func(value any, item map[string]any, args ...string) any {
+		return item["other"]
+	})
+	if _, all := syncer.ParseMapping(map[string]string{"id": "raw|undeclared"}).SourceFields(); !all {
+		t.Fatal("expected a resolver not declaring its reads to read all fields")
+	}
+	if _, all := syncer.ParseMapping(map[string]string{"id": "raw|trim|int"}).SourceFields(); all {
+		t.Fatal("expected resolvers reading their value to read only the mapped fields")
+	}
+}
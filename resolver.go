@@ -5,29 +5,169 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+
+	"github.com/enorith/syncer/ds"
 )
 
 type Resolver func(value interface{}, item map[string]interface{}, args ...string) interface{}
 
+// RunResolver a resolver instance living for one run, so it can keep state such as caches
+type RunResolver interface {
+	Resolve(value any, item map[string]any, args ...string) (any, error)
+}
+
+// Prefetcher is implemented by run resolvers which load what a whole page needs at once,
+// Prefetch gets the values of the page before Resolve is called for each of them
+type Prefetcher interface {
+	Prefetch(values []any, args ...string) error
+}
+
+// ResolverFactory creates the instance of a resolver for a run
+type ResolverFactory func() RunResolver
+
+type resolverInfo struct {
+	output string
+	reads  func(args ...string) []string
+	row    bool
+	// declared whether the resolver declares the fields it reads
+	declared bool
+}
+
+// ResolverOption describes a registered resolver
+type ResolverOption func(info *resolverInfo)
+
 var (
-	valueResolvers = make(map[string]Resolver)
-	resolverMu     sync.RWMutex
+	valueResolvers    = make(map[string]Resolver)
+	resolverFactories = make(map[string]ResolverFactory)
+	resolverInfos     = make(map[string]resolverInfo)
+	resolverMu        sync.RWMutex
 )
 
-func RegisterValueResolver(name string, resolver Resolver) {
+// ResolverOutput declares the normalized type (ds.TypeInt...) a resolver returns,
+// used to infer target columns, resolvers without it keep the type of their input
+func ResolverOutput(typ string) ResolverOption {
+	return func(info *resolverInfo) {
+		info.output = typ
+	}
+}
+
+// ResolverReads declares the fields of the source row a resolver reads besides its value,
+// given its args, so they are selected from the source
+func ResolverReads(reads func(args ...string) []string) ResolverOption {
+	return func(info *resolverInfo) {
+		info.reads, info.declared = reads, true
+	}
+}
+
+// ResolverReadsValue declares a resolver only reads its value, tasks using resolvers which declare
+// none of ResolverReads, ResolverReadsValue or ResolverReadsRow select whole rows
+func ResolverReadsValue() ResolverOption {
+	return func(info *resolverInfo) {
+		info.declared = true
+	}
+}
+
+// ResolverReadsRow declares a resolver reads fields of the source row it can not name,
+// tasks using it select whole rows
+func ResolverReadsRow() ResolverOption {
+	return func(info *resolverInfo) {
+		info.row, info.declared = true, true
+	}
+}
+
+func RegisterValueResolver(name string, resolver Resolver, opts ...ResolverOption) {
 	resolverMu.Lock()
 	defer resolverMu.Unlock()
 	valueResolvers[name] = resolver
+	delete(resolverFactories, name)
+	setResolverInfo(name, opts)
+}
+
+// RegisterResolverFactory registers a resolver instantiated once per run
+func RegisterResolverFactory(name string, factory ResolverFactory, opts ...ResolverOption) {
+	resolverMu.Lock()
+	defer resolverMu.Unlock()
+	resolverFactories[name] = factory
+	delete(valueResolvers, name)
+	setResolverInfo(name, opts)
+}
+
+func setResolverInfo(name string, opts []ResolverOption) {
+	var info resolverInfo
+	for _, opt := range opts {
+		opt(&info)
+	}
+
+	resolverInfos[name] = info
+}
+
+// ResolverOutputType the declared output type of a resolver
+func ResolverOutputType(name string) (string, bool) {
+	resolverMu.RLock()
+	defer resolverMu.RUnlock()
+	info := resolverInfos[name]
+
+	return info.output, info.output != ""
+}
+
+// resolverReads the fields of the source row a resolver call reads, row when it reads whole rows
+// or does not declare what it reads
+func resolverReads(call ResolverCall) (fields []string, row bool) {
+	resolverMu.RLock()
+	info := resolverInfos[call.Name]
+	resolverMu.RUnlock()
+
+	if info.reads != nil {
+		fields = info.reads(call.Args...)
+	}
+
+	return fields, info.row || !info.declared
 }
 
 func ResolveValue(value interface{}, item map[string]interface{}, resolver string, args ...string) interface{} {
-	if r, ok := valueResolvers[resolver]; ok {
+	resolverMu.RLock()
+	r, ok := valueResolvers[resolver]
+	factory, isFactory := resolverFactories[resolver]
+	resolverMu.RUnlock()
+
+	if ok {
 		return r(value, item, args...)
 	}
 
+	if isFactory {
+		if resolved, e := factory().Resolve(value, item, args...); e == nil {
+			return resolved
+		}
+	}
+
 	return value
 }
 
+// funcResolver runs a plain resolver as a run resolver
+type funcResolver Resolver
+
+func (r funcResolver) Resolve(value any, item map[string]any, args ...string) (any, error) {
+	return r(value, item, args...), nil
+}
+
+// newRunResolver an instance of a registered resolver, nil when it is not registered
+func newRunResolver(name string) RunResolver {
+	resolverMu.RLock()
+	r, ok := valueResolvers[name]
+	factory, isFactory := resolverFactories[name]
+	resolverMu.RUnlock()
+
+	if ok {
+		return funcResolver(r)
+	}
+
+	if isFactory {
+		return factory()
+	}
+
+	return nil
+}
+
 func trimResolver(value interface{}, item map[string]interface{}, args ...string) interface{} {
 	if str, ok := value.(string); ok {
 		return strings.TrimSpace(str)
@@ -51,6 +191,7 @@ func intResolver(value interface{}, item map[string]interface{}, args ...string)
 }
 
 func init() {
-	RegisterValueResolver("trim", trimResolver)
-	RegisterValueResolver("int", intResolver)
+	RegisterValueResolver("trim", trimResolver, ResolverReadsValue())
+	RegisterValueResolver("int", intResolver, ResolverOutput(ds.TypeInt), ResolverReadsValue())
+	RegisterResolverFactory("lookup", NewLookupResolver, ResolverReadsValue())
 }
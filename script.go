@@ -0,0 +1,369 @@
+package syncer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"runtime/metrics"
+	"strings"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+var (
+	DefaultScriptTimeout = time.Second
+	// DefaultScriptMemoryLimit megabytes a row may allocate
+	DefaultScriptMemoryLimit = 128
+	// scriptMemoryInterval how often the allocations of a running row are checked
+	scriptMemoryInterval = 5 * time.Millisecond
+
+	errScriptMemory = errors.New("[syncer] script: memory limit exceeded")
+
+	// scriptLibs libraries scripts can use, without io, os or loading code
+	scriptLibs = []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	}
+	// scriptRemoved globals removed from the libraries, loading code, writing to stdout and string.rep
+	// which builds large strings in one call, before the memory limit is checked
+	scriptRemoved       = []string{"dofile", "loadfile", "load", "loadstring", "require", "module", "collectgarbage", "print"}
+	scriptRemovedString = []string{"rep"}
+)
+
+// ScriptConfig lua transform of a task, the script defines transform(row, meta) returning the row,
+// a list of rows, or nil to skip it. nil fields are dropped, lua tables can not hold them
+type ScriptConfig struct {
+	// Source the script, or File a path to it
+	Source string `json:"source"`
+	File   string `json:"file"`
+	// Timeout of a row, 1s by default
+	Timeout string `json:"timeout"`
+	// RegistryMaxSize caps the growth of the value registry, CallStackSize the depth of calls
+	RegistryMaxSize int `json:"registry_max_size"`
+	CallStackSize   int `json:"call_stack_size"`
+	// MemoryLimit megabytes allocated while a row runs, 128 by default. Allocations are counted
+	// for the process, rows transformed concurrently count towards each other's limit
+	MemoryLimit int `json:"memory_limit"`
+}
+
+// ScriptTransformer runs a lua script compiled once per run, each page borrows a sandboxed state from a pool
+type ScriptTransformer struct {
+	conf    ScriptConfig
+	proto   *lua.FunctionProto
+	timeout time.Duration
+	memory  uint64
+	states  chan *lua.LState
+}
+
+func (st *ScriptTransformer) Transform(ctx context.Context, row map[string]any) ([]map[string]any, error) {
+	return st.TransformPage(ctx, []map[string]any{row})
+}
+
+func (st *ScriptTransformer) TransformPage(ctx context.Context, rows []map[string]any) ([]map[string]any, error) {
+	L, e := st.state()
+	if e != nil {
+		return nil, e
+	}
+
+	meta := L.NewTable()
+	if m := TransformMeta(ctx); m != nil {
+		meta.RawSetString("task_id", lua.LString(m.TaskID))
+		meta.RawSetString("target", lua.LString(m.Target))
+		meta.RawSetString("version", lua.LNumber(m.Version))
+		meta.RawSetString("total", lua.LNumber(m.Total))
+	}
+	meta.RawSetString("page", lua.LNumber(TransformPage(ctx)))
+
+	var transformed []map[string]any
+	for _, row := range rows {
+		out, e := st.call(ctx, L, row, meta)
+		if e != nil {
+			// a state interrupted mid call is not reused
+			L.Close()
+			return nil, e
+		}
+		transformed = append(transformed, out...)
+	}
+
+	st.release(L)
+
+	return transformed, nil
+}
+
+func (st *ScriptTransformer) call(ctx context.Context, L *lua.LState, row map[string]any, meta *lua.LTable) ([]map[string]any, error) {
+	ctx, cancel := context.WithTimeout(ctx, st.timeout)
+	defer cancel()
+	ctx, abort := context.WithCancelCause(ctx)
+	defer abort(nil)
+	go st.watchMemory(ctx, abort)
+
+	L.SetContext(ctx)
+	defer L.RemoveContext()
+
+	e := L.CallByParam(lua.P{Fn: L.GetGlobal("transform"), NRet: 1, Protect: true}, toLua(L, row), meta)
+	if e != nil {
+		if errors.Is(context.Cause(ctx), errScriptMemory) {
+			return nil, errScriptMemory
+		}
+		return nil, fmt.Errorf("[syncer] script: %w", e)
+	}
+
+	ret := L.Get(-1)
+	L.Pop(1)
+
+	table, ok := ret.(*lua.LTable)
+	if !ok {
+		if ret == lua.LNil || ret == lua.LFalse {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("[syncer] script: transform returned %s, expected a table or nil", ret.Type())
+	}
+
+	if table.MaxN() == 0 {
+		out, _ := fromLua(table).(map[string]any)
+		return []map[string]any{out}, nil
+	}
+
+	var rows []map[string]any
+	for i := 1; i <= table.MaxN(); i++ {
+		out, ok := fromLua(table.RawGetInt(i)).(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("[syncer] script: transform returned a list with a non table at %d", i)
+		}
+		rows = append(rows, out)
+	}
+
+	return rows, nil
+}
+
+// watchMemory aborts the call of ctx once the process allocated more than the memory limit since it started
+func (st *ScriptTransformer) watchMemory(ctx context.Context, abort context.CancelCauseFunc) {
+	sample := []metrics.Sample{{Name: "/gc/heap/allocs:bytes"}}
+	metrics.Read(sample)
+	start := sample[0].Value.Uint64()
+
+	ticker := time.NewTicker(scriptMemoryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			metrics.Read(sample)
+			if sample[0].Value.Uint64()-start > st.memory {
+				abort(errScriptMemory)
+				return
+			}
+		}
+	}
+}
+
+func (st *ScriptTransformer) state() (*lua.LState, error) {
+	select {
+	case L := <-st.states:
+		return L, nil
+	default:
+	}
+
+	L := lua.NewState(lua.Options{
+		SkipOpenLibs:    true,
+		CallStackSize:   st.conf.CallStackSize,
+		RegistryMaxSize: st.conf.RegistryMaxSize,
+	})
+
+	for _, lib := range scriptLibs {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range scriptRemoved {
+		L.SetGlobal(name, lua.LNil)
+	}
+	if str, ok := L.GetGlobal(lua.StringLibName).(*lua.LTable); ok {
+		for _, name := range scriptRemovedString {
+			str.RawSetString(name, lua.LNil)
+		}
+	}
+
+	L.Push(L.NewFunctionFromProto(st.proto))
+	if e := L.PCall(0, lua.MultRet, nil); e != nil {
+		L.Close()
+		return nil, fmt.Errorf("[syncer] script: %w", e)
+	}
+
+	if L.GetGlobal("transform").Type() != lua.LTFunction {
+		L.Close()
+		return nil, errors.New("[syncer] script must define function transform(row, meta)")
+	}
+
+	return L, nil
+}
+
+func (st *ScriptTransformer) release(L *lua.LState) {
+	select {
+	case st.states <- L:
+	default:
+		L.Close()
+	}
+}
+
+// Close closes the pooled states
+func (st *ScriptTransformer) Close() {
+	for {
+		select {
+		case L := <-st.states:
+			L.Close()
+		default:
+			return
+		}
+	}
+}
+
+// NewScriptTransformer compiles the script of conf
+func NewScriptTransformer(conf ScriptConfig) (*ScriptTransformer, error) {
+	source := conf.Source
+	if conf.File != "" {
+		b, e := os.ReadFile(conf.File)
+		if e != nil {
+			return nil, e
+		}
+		source = string(b)
+	}
+
+	name := conf.File
+	if name == "" {
+		name = "script"
+	}
+
+	chunk, e := parse.Parse(strings.NewReader(source), name)
+	if e != nil {
+		return nil, fmt.Errorf("[syncer] script: %w", e)
+	}
+
+	proto, e := lua.Compile(chunk, name)
+	if e != nil {
+		return nil, fmt.Errorf("[syncer] script: %w", e)
+	}
+
+	timeout := DefaultScriptTimeout
+	if conf.Timeout != "" {
+		if timeout, e = time.ParseDuration(conf.Timeout); e != nil {
+			return nil, e
+		}
+	}
+
+	memory := conf.MemoryLimit
+	if memory <= 0 {
+		memory = DefaultScriptMemoryLimit
+	}
+
+	st := &ScriptTransformer{conf: conf, proto: proto, timeout: timeout, memory: uint64(memory) << 20, states: make(chan *lua.LState, 16)}
+
+	// load the script once, so errors of its top level code fail the run before it starts
+	L, e := st.state()
+	if e != nil {
+		return nil, e
+	}
+	st.release(L)
+
+	return st, nil
+}
+
+func toLua(L *lua.LState, value any) lua.LValue {
+	switch v := value.(type) {
+	case nil:
+		return lua.LNil
+	case bool:
+		return lua.LBool(v)
+	case string:
+		return lua.LString(v)
+	case []byte:
+		return lua.LString(v)
+	case int:
+		return lua.LNumber(v)
+	case int8:
+		return lua.LNumber(v)
+	case int16:
+		return lua.LNumber(v)
+	case int32:
+		return lua.LNumber(v)
+	case int64:
+		return lua.LNumber(v)
+	case uint:
+		return lua.LNumber(v)
+	case uint8:
+		return lua.LNumber(v)
+	case uint16:
+		return lua.LNumber(v)
+	case uint32:
+		return lua.LNumber(v)
+	case uint64:
+		return lua.LNumber(v)
+	case float32:
+		return lua.LNumber(v)
+	case float64:
+		return lua.LNumber(v)
+	case json.Number:
+		f, _ := v.Float64()
+		return lua.LNumber(f)
+	case time.Time:
+		return lua.LString(v.Format(DefaultTimeFormat))
+	case map[string]any:
+		table := L.NewTable()
+		for k, item := range v {
+			table.RawSetString(k, toLua(L, item))
+		}
+		return table
+	case []any:
+		table := L.NewTable()
+		for _, item := range v {
+			table.Append(toLua(L, item))
+		}
+		return table
+	}
+
+	return lua.LString(fmt.Sprint(value))
+}
+
+// fromLua converts lua values back, whole numbers to int64 and tables to lists or maps
+func fromLua(value lua.LValue) any {
+	switch v := value.(type) {
+	case lua.LBool:
+		return bool(v)
+	case lua.LString:
+		return string(v)
+	case lua.LNumber:
+		if f := float64(v); f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+			return int64(f)
+		}
+		return float64(v)
+	case *lua.LTable:
+		if n := v.MaxN(); n > 0 {
+			list := make([]any, n)
+			for i := 1; i <= n; i++ {
+				list[i-1] = fromLua(v.RawGetInt(i))
+			}
+			return list
+		}
+
+		m := make(map[string]any)
+		v.ForEach(func(k, item lua.LValue) {
+			m[k.String()] = fromLua(item)
+		})
+		return m
+	}
+
+	return nil
+}
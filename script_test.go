@@ -0,0 +1,75 @@
+package syncer_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/enorith/syncer"
+)
+
+func TestScriptTransformer(t *testing.T) {
+	script, e := syncer.NewScriptTransformer(syncer.ScriptConfig{Source: `
+		function transform(row, meta)
+			if row.age < 18 then
+				return nil
+			end
+			if row.twins then
+				return {{name = row.name .. "1"}, {name = row.name .. "2"}}
+			end
+			row.name = string.upper(row.name)
+			row.task = meta.task_id
+			return row
+		end
+	`})
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer script.Close()
+
+	rows, e := script.TransformPage(context.Background(), []map[string]any{
+		{"name": "a", "age": 20},
+		{"name": "b", "age": 10},
+		{"name": "c", "age": int64(30), "twins": true},
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(rows) != 3 || rows[0]["name"] != "A" || rows[0]["age"] != int64(20) || rows[2]["name"] != "c2" {
+		t.Fatalf("unexpected rows: %v", rows)
+	}
+
+	loop, e := syncer.NewScriptTransformer(syncer.ScriptConfig{Timeout: "50ms", Source: `function transform(row) while true do end end`})
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer loop.Close()
+	if _, e := loop.Transform(context.Background(), map[string]any{}); e == nil {
+		t.Fatal("expected timeout error")
+	}
+
+	alloc, e := syncer.NewScriptTransformer(syncer.ScriptConfig{Timeout: "10s", MemoryLimit: 8, Source: `function transform(row)
+		local t = {}
+		for i = 1, 100000000 do t[i] = "row " .. i end
+		return row
+	end`})
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer alloc.Close()
+	if _, e := alloc.Transform(context.Background(), map[string]any{}); e == nil || !strings.Contains(e.Error(), "memory limit") {
+		t.Fatalf("expected memory limit error, got %v", e)
+	}
+
+	sandboxed, _ := syncer.NewScriptTransformer(syncer.ScriptConfig{Source: `function transform(row)
+		return {os = tostring(os), io = tostring(io), load = tostring(load), print = tostring(print), rep = tostring(("x").rep)}
+	end`})
+	out, e := sandboxed.Transform(context.Background(), map[string]any{})
+	if e != nil || out[0]["os"] != "nil" || out[0]["io"] != "nil" || out[0]["load"] != "nil" || out[0]["print"] != "nil" || out[0]["rep"] != "nil" {
+		t.Fatalf("expected sandboxed globals, got %v %v", out, e)
+	}
+
+	if _, e := syncer.NewScriptTransformer(syncer.ScriptConfig{Source: `x = `}); e == nil || !strings.Contains(e.Error(), "script") {
+		t.Fatalf("expected compile error, got %v", e)
+	}
+}
@@ -2,14 +2,17 @@ package syncer
 
 import (
 	"fmt"
+	"log"
 	"math"
 	"math/rand"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/alitto/pond"
+	"github.com/enorith/supports/collection"
 	"github.com/enorith/syncer/ds"
 	"github.com/go-co-op/gocron"
 )
@@ -20,13 +23,37 @@ type SyncerTask struct {
 	Mapping map[string]string `json:"mapping"`
 	Filters []ds.ListFilter   `json:"filters"`
 	Orders  []ds.ListOrder    `json:"orders"`
+	// Selects source fields read besides those the mapping reads, ["*"] reads whole rows
+	Selects []string `json:"selects"`
+	// Keyset reads pages after the primary keys of the previous page instead of by offset,
+	// pages are read one after another and written by the workers
+	Keyset bool `json:"keyset"`
 
 	Target       string       `json:"target"`
 	TargetConfig TargetConfig `json:"target_config"`
+	// Targets fan-out targets, used instead of Target and TargetConfig when set
+	Targets []TaskTarget `json:"targets"`
 
 	Size        int64 `json:"size"`
 	Workers     int   `json:"workers"`
 	StopOnError bool  `json:"stop_on_error"`
+	// Transformers registered RowTransformer names, run in order on the mapped rows of each target
+	Transformers []string `json:"transformers"`
+	// Script lua transform run after the transformers
+	Script *ScriptConfig `json:"script"`
+	// Dedup deduplicates the transformed rows of each target by key
+	Dedup *DedupConfig `json:"dedup"`
+	// Aggregate rolls the mapped rows up by group before the transformers
+	Aggregate *AggregateConfig `json:"aggregate"`
+
+	// Group and Tenant the template and tenant a task was expanded from
+	Group  string `json:"group"`
+	Tenant string `json:"tenant"`
+
+	// DependsOn tasks which must succeed before this task runs in a graph
+	DependsOn []string `json:"depends_on"`
+	// DriftPolicy fail, warn (default) or ignore when the mapping does not match the source or target schema
+	DriftPolicy string `json:"drift_policy"`
 
 	// At 每天的时间，interval 为nd时有效
 	At          string `json:"at"`
@@ -39,13 +66,37 @@ const (
 	SyncStatusRunning
 	SyncStatusSuccess
 	SyncStatusFailed
+	// SyncStatusSkipped a task of a graph not run as an upstream task did not succeed
+	SyncStatusSkipped
 )
 
 type SyncMeta struct {
+	TaskID  string
+	Target  string
 	Version int
 	Total   int64
 	Status  int
 	Error   error
+
+	// Inserted, Updated and Unchanged row counts reported by targets with change detection,
+	// batches are written concurrently so they are updated with sync/atomic
+	Inserted  int64
+	Updated   int64
+	Unchanged int64
+	// FailedPages pages not written as reading, mapping or writing them failed, the run goes on past
+	// them unless StopOnError, updated with sync/atomic
+	FailedPages int64
+
+	// Columns target columns inferred from the source schema and the mapping
+	Columns []ds.Column
+	// Targets meta of each target of a run
+	Targets []*SyncMeta
+}
+
+// Complete whether the run succeeded with every page written, rows missing from an incomplete
+// run may exist in the source
+func (meta *SyncMeta) Complete() bool {
+	return meta.Status == SyncStatusSuccess && atomic.LoadInt64(&meta.FailedPages) == 0
 }
 
 type Syncer struct {
@@ -53,6 +104,7 @@ type Syncer struct {
 	mu    sync.RWMutex
 }
 
+// AddTask adds tasks without checking their dependencies, RunGraph checks them before it runs
 func (s *Syncer) AddTask(tasks ...SyncerTask) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -62,6 +114,28 @@ func (s *Syncer) AddTask(tasks ...SyncerTask) {
 	}
 }
 
+// AddTasks adds tasks, none of them are added when they depend on unknown tasks or make a dependency cycle
+func (s *Syncer) AddTasks(tasks ...SyncerTask) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	merged := make(map[string]SyncerTask, len(s.tasks)+len(tasks))
+	for id, task := range s.tasks {
+		merged[id] = task
+	}
+	for _, task := range tasks {
+		merged[task.ID] = task
+	}
+
+	if e := checkDependencies(merged); e != nil {
+		return e
+	}
+
+	s.tasks = merged
+
+	return nil
+}
+
 func (s *Syncer) GetTask(id string) (SyncerTask, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -71,30 +145,57 @@ func (s *Syncer) GetTask(id string) (SyncerTask, bool) {
 	return task, ok
 }
 
+// Schedule runs each task with an interval and the tasks depending on it, a task with a scheduled
+// upstream task only runs with its graph so its own interval is ignored
 func (s *Syncer) Schedule(sch *gocron.Scheduler) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	for _, task := range s.tasks {
+		if upstream, ok := scheduledUpstream(s.tasks, task.ID); ok && task.Interval != "" {
+			log.Printf("[syncer] interval of task %s ignored, it runs with the graph of %s", task.ID, upstream)
+			continue
+		}
+
 		if task.Interval != "" {
 			if isD, sub := EndWith(task.Interval, "d", "day", "days"); isD {
 				i := task.Interval[:len(task.Interval)-len(sub)]
 
 				ev, e := strconv.Atoi(i)
 				if e == nil {
-					sch.Every(ev).Day().Tag("syncer:" + task.ID).Do(func() {
-						s.SyncTask(task)
+					sch.Every(ev).Day().Tag(task.scheduleTags()...).Do(func() {
+						s.RunGraph(task.ID)
 					})
 				}
 			} else {
-				sch.Every(task.Interval).Tag("syncer:" + task.ID).Do(func() {
-					s.SyncTask(task)
+				sch.Every(task.Interval).Tag(task.scheduleTags()...).Do(func() {
+					s.RunGraph(task.ID)
 				})
 			}
 		}
 	}
 }
 
+// workers writing pages, a run scoped dedup writes them one after another in page order so the
+// same page wins every run
+func (task SyncerTask) workers() int {
+	if task.Dedup != nil && task.Dedup.Scope == DedupScopeRun {
+		return 1
+	}
+
+	return task.Workers
+}
+
+// scheduleTags tags the job of a task, tasks of a group also share the group tag
+func (task SyncerTask) scheduleTags() []string {
+	tags := []string{"syncer:" + task.ID}
+	if task.Group != "" {
+		tags = append(tags, "syncer-group:"+task.Group)
+	}
+
+	return tags
+}
+
 func (s *Syncer) DoSync(id string) (int64, error) {
 	task, ok := s.GetTask(id)
 
@@ -106,127 +207,414 @@ func (s *Syncer) DoSync(id string) (int64, error) {
 }
 
 func (s *Syncer) SyncTask(task SyncerTask) (int64, error) {
+	run, e := s.RunTask(task)
+
+	return run.Total, e
+}
+
+// RunTask syncs a task, the returned meta holds the status of the run and the meta of each target in Targets
+func (s *Syncer) RunTask(task SyncerTask) (*SyncMeta, error) {
+	run := &SyncMeta{TaskID: task.ID, Status: SyncStatusPending}
+
 	dataSource, e := ds.Connect(task.Source)
 	if e != nil {
-		return 0, e
+		return run, e
+	}
+
+	agg, e := newAggregatePlan(task, dataSource)
+	if e != nil {
+		return run, e
 	}
 
-	meta, e := dataSource.ListMeta(task.Filters...)
+	// sequential sources are counted as their pages are read
+	sequential := !task.Keyset && isSequential(dataSource)
+
+	var meta ds.ListMeta
+	switch {
+	case sequential:
+	case agg != nil && agg.pushdown:
+		// the total of a pushed down aggregation is its number of groups
+		var result ds.ListResult
+		result, e = dataSource.List(agg.listOption(ds.ListOption{Limit: 1, Filters: task.Filters}))
+		meta = result.Meta
+	default:
+		meta, e = dataSource.ListMeta(task.Filters...)
+	}
 
 	if e != nil {
-		return 0, e
+		return run, e
 	}
 
-	if meta.Total == 0 {
-		return 0, nil
+	run.Total = meta.Total
+	if meta.Total == 0 && !sequential {
+		run.Status = SyncStatusSuccess
+		return run, nil
 	}
 
-	pool := pond.New(task.Workers, 1000)
+	schema, e := sourceSchema(dataSource)
+	if e != nil {
+		return run, e
+	}
 
-	maxPage := int(math.Ceil(float64(meta.Total) / float64(task.Size)))
+	transformers, e := task.taskTransformers()
+	if e != nil {
+		return run, e
+	}
 
-	target, ok := GetTarget(task.Target)
+	if task.Script != nil {
+		script, e := NewScriptTransformer(*task.Script)
+		if e != nil {
+			return run, e
+		}
+		defer script.Close()
 
-	if !ok {
-		return 0, fmt.Errorf("[syncer] target not found: %s", task.Target)
+		transformers = append(transformers, script)
 	}
 
-	var syncMeta = SyncMeta{
-		Total:  meta.Total,
-		Status: SyncStatusPending,
+	selects := task.sourceSelects(schema)
+	if agg != nil && agg.pushdown {
+		selects = nil
 	}
 
-	e = target.BeforeSync(task.TargetConfig, &syncMeta)
+	keys, e := task.keysetKeys(dataSource, agg)
 	if e != nil {
-		return meta.Total, e
+		return run, e
+	}
+	if keys != nil && selects != nil {
+		for _, key := range keys {
+			if !collection.Contains(selects, key) {
+				selects = append(selects, key)
+			}
+		}
 	}
 
-	var syncFunc = func(page int64) error {
-		data, e := dataSource.List(ds.ListOption{
-			Page:        int64(page),
-			WithoutMeta: true,
-			Filters:     task.Filters,
-			Orders:      task.Orders,
-			Limit:       task.Size,
-		})
+	listOpt := agg.listOption(ds.ListOption{
+		WithoutMeta: true,
+		Selects:     selects,
+		Filters:     task.Filters,
+		Orders:      task.Orders,
+		Limit:       task.Size,
+	})
+
+	var first []any
+	if sequential {
+		opt := listOpt
+		opt.Page = 1
+		result, e := dataSource.List(opt)
+		if e != nil {
+			return run, e
+		}
+
+		if len(result.Data) == 0 {
+			run.Status = SyncStatusSuccess
+			return run, nil
+		}
+		first = result.Data
+	}
 
+	var targets []*runTarget
+	for _, tt := range task.taskTargets() {
+		target, e := ResolveTarget(tt.Target)
 		if e != nil {
-			return e
+			return run, e
 		}
 
-		if len(data.Data) == 0 {
-			return nil
+		mapping := ParseMapping(tt.Mapping)
+		if e := checkDrift(task, tt, mapping, schema, target); e != nil {
+			return run, e
 		}
 
-		var syncData []map[string]any
-		for _, dsItem := range data.Data {
-			if m, ok := dsItem.(map[string]any); ok {
-				item := make(map[string]any)
-				for k, v := range task.Mapping {
-					parts := strings.Split(v, ";")
-					value := m[k]
-					for _, part := range parts {
-						resolvers := strings.Split(part, "|")
-						pk := resolvers[0]
-						if len(resolvers) > 1 {
-							for _, resolver := range resolvers[1:] {
-								partsParams := strings.Split(resolver, ":")
-								var args []string
-								if len(partsParams) > 1 {
-									paramStr := partsParams[1]
-									args = strings.Split(paramStr, ",")
-									resolver = partsParams[0]
-								}
-								value = ResolveValue(value, m, resolver, args...)
-							}
-						}
-						item[pk] = value
-					}
-				}
-				syncData = append(syncData, item)
+		rt := &runTarget{TaskTarget: tt, target: target, mapping: mapping.NewRun(), meta: &SyncMeta{
+			TaskID:  task.ID,
+			Target:  tt.Target,
+			Total:   meta.Total,
+			Status:  SyncStatusPending,
+			Columns: mapping.Columns(schema),
+		}}
+
+		if agg != nil {
+			rt.meta.Columns = agg.targetColumns(rt.meta.Columns)
+			if !agg.pushdown {
+				rt.aggregation = ds.NewAggregation(agg.conf.GroupBy, agg.conf.Aggregates)
+			}
+		}
+
+		if task.Dedup != nil {
+			if rt.dedup, e = newDeduper(*task.Dedup, tt); e != nil {
+				return run, e
+			}
+			defer rt.dedup.close()
+		}
+
+		targets = append(targets, rt)
+		run.Targets = append(run.Targets, rt.meta)
+	}
+
+	for i, rt := range targets {
+		if e := rt.target.BeforeSync(rt.TargetConfig, rt.meta); e != nil {
+			// targets prepared before are ended as failed, so they clean up
+			for _, prepared := range targets[:i] {
+				prepared.meta.Status = SyncStatusFailed
+				prepared.target.AfterSync(prepared.TargetConfig, prepared.meta)
 			}
+
+			rt.meta.Status, rt.meta.Error = SyncStatusFailed, e
+			run.Status, run.Error = SyncStatusFailed, e
+			return run, e
+		}
+	}
+
+	pool := pond.New(task.workers(), 1000)
+
+	maxPage := int(math.Ceil(float64(meta.Total) / float64(task.Size)))
+
+	// write transforms, deduplicates and writes the mapped rows of a page to a target
+	var write = func(rt *runTarget, page int64, syncData []map[string]any) {
+		syncData, e := transformRows(transformContext(rt.meta, page), transformers, syncData)
+		if e == nil && rt.dedup != nil {
+			syncData, e = rt.dedup.dedup(syncData)
+		}
+
+		if e != nil {
+			rt.failPage(page, e, task.StopOnError)
+			return
+		}
+
+		if len(syncData) == 0 {
+			return
+		}
+
+		if e := rt.target.SyncFrom(rt.TargetConfig, syncData, rt.meta); e != nil {
+			rt.failPage(page, e, task.StopOnError)
+		}
+	}
+
+	var activeTargets = func() []*runTarget {
+		return collection.Filter(targets, func(rt *runTarget) bool {
+			return !rt.failed.Load()
+		})
+	}
+
+	// process maps and writes a page of source rows to the active targets
+	var process = func(page int64, data []any) {
+		active := activeTargets()
+		if len(active) == 0 {
+			return
 		}
 
 		delayRand := time.Duration(10+rand.Intn(20)) * time.Millisecond
 
 		<-time.After(delayRand)
-		return target.SyncFrom(task.TargetConfig, syncData, &syncMeta)
+
+		var rows []map[string]any
+		for _, dsItem := range data {
+			if m, ok := dsItem.(map[string]any); ok {
+				rows = append(rows, m)
+			}
+		}
+
+		for _, rt := range active {
+			if agg != nil && agg.pushdown {
+				write(rt, page, agg.rename(rows))
+				continue
+			}
+
+			syncData, e := rt.mapping.ApplyPage(rows)
+			if e != nil {
+				rt.failPage(page, e, task.StopOnError)
+				continue
+			}
+
+			if rt.aggregation != nil {
+				rt.aggregation.Add(syncData...)
+				continue
+			}
+
+			write(rt, page, syncData)
+		}
 	}
 
-	var (
-		errChan = make(chan error)
-		exit    = make(chan struct{})
-	)
+	var read atomic.Int64
+	switch {
+	case keys != nil:
+		// keyset pages are read one after another, each after the keys of the last row of the previous
+		e := readKeyset(dataSource, listOpt, keys, func(page int64, data []any) bool {
+			pool.Submit(func() {
+				process(page, data)
+			})
 
-	for page := 1; page <= maxPage; page++ {
-		p := page
-		pool.Submit(func() {
-			e := syncFunc(int64(p))
-			if e != nil && task.StopOnError {
-				errChan <- e
+			return len(activeTargets()) > 0
+		})
+		if e != nil {
+			for _, rt := range activeTargets() {
+				rt.fail(e)
 			}
+		}
+	case sequential:
+		e := readSequential(dataSource, listOpt, first, func(page int64, data []any) bool {
+			read.Add(int64(len(data)))
+			pool.Submit(func() {
+				process(page, data)
+			})
+
+			return len(activeTargets()) > 0
 		})
+		if e != nil {
+			for _, rt := range activeTargets() {
+				rt.fail(e)
+			}
+		}
+	default:
+		var syncFunc = func(page int64) {
+			active := activeTargets()
+			if len(active) == 0 {
+				return
+			}
+
+			opt := listOpt
+			opt.Page = page
+			data, e := dataSource.List(opt)
+
+			if e != nil {
+				for _, rt := range active {
+					rt.failPage(page, e, task.StopOnError)
+				}
+				return
+			}
+
+			if len(data.Data) == 0 {
+				return
+			}
+
+			process(page, data.Data)
+		}
+
+		for page := 1; page <= maxPage; page++ {
+			p := page
+			pool.Submit(func() {
+				syncFunc(int64(p))
+			})
+		}
 	}
 
-	go func() {
-		pool.StopAndWait()
-		exit <- struct{}{}
-	}()
+	pool.StopAndWait()
 
-	select {
-	case <-exit:
-		syncMeta.Status = SyncStatusSuccess
-	case err := <-errChan:
-		syncMeta.Status = SyncStatusFailed
-		syncMeta.Error = err
+	if sequential {
+		run.Total = read.Load()
+		for _, rt := range targets {
+			rt.meta.Total = run.Total
+		}
 	}
 
-	e = target.AfterSync(task.TargetConfig, &syncMeta)
-	if e != nil {
-		return meta.Total, e
+	// groups aggregated in memory are written once every page is read, in pages of the task size
+	for _, rt := range targets {
+		if rt.aggregation == nil || rt.failed.Load() {
+			continue
+		}
+
+		rows := rt.aggregation.Rows()
+		for i, page := 0, int64(1); i < len(rows) && !rt.failed.Load(); i, page = i+int(task.Size), page+1 {
+			write(rt, page, rows[i:min(i+int(task.Size), len(rows))])
+		}
+	}
+
+	run.Status = SyncStatusSuccess
+
+	var afterErr error
+	for _, rt := range targets {
+		rt.finish(run)
+
+		if e := rt.target.AfterSync(rt.TargetConfig, rt.meta); e != nil {
+			rt.meta.Status, rt.meta.Error = SyncStatusFailed, e
+			if afterErr == nil {
+				afterErr = e
+			}
+		}
+
+		if rt.meta.Status == SyncStatusFailed && run.Error == nil {
+			run.Status, run.Error = SyncStatusFailed, rt.meta.Error
+		}
+	}
+
+	if afterErr != nil {
+		return run, afterErr
+	}
+
+	return run, run.Error
+}
+
+// sourceSchema columns of the source, nil when the datasource can not describe them
+func sourceSchema(source ds.Datasource) ([]ds.Column, error) {
+	if provider, ok := source.(ds.SchemaProvider); ok {
+		return provider.Schema()
+	}
+
+	return nil, nil
+}
+
+func isSequential(source ds.Datasource) bool {
+	sequential, ok := source.(ds.Sequential)
+
+	return ok && sequential.Sequential()
+}
+
+// readSequential lists the pages of a sequential source in order, starting with the rows of the
+// first page, until an empty page or fn returns false
+func readSequential(source ds.Datasource, opt ds.ListOption, first []any, fn func(page int64, data []any) bool) error {
+	data := first
+	for page := int64(1); len(data) > 0; page++ {
+		if !fn(page, data) {
+			return nil
+		}
+
+		opt.Page = page + 1
+		result, e := source.List(opt)
+		if e != nil {
+			return e
+		}
+		data = result.Data
+	}
+
+	return nil
+}
+
+// sourceSelects the source fields the mappings of the targets read and Selects, nil to read whole rows.
+// Whole rows are read without a schema, or when a field is missing from it, as selecting it would fail
+// the query, the drift check reports the field
+func (task SyncerTask) sourceSelects(schema []ds.Column) []string {
+	fields := append([]string{}, task.Selects...)
+	for _, tt := range task.taskTargets() {
+		read, all := ParseMapping(tt.Mapping).SourceFields()
+		if all {
+			return nil
+		}
+		fields = append(fields, read...)
+	}
+
+	if len(fields) == 0 || collection.Contains(fields, "*") {
+		return nil
+	}
+
+	if schema == nil {
+		return nil
+	}
+
+	byName := columnsByName(schema)
+	seen := make(map[string]bool, len(fields))
+	var selects []string
+	for _, field := range fields {
+		column, ok := byName[strings.ToLower(field)]
+		if !ok {
+			return nil
+		}
+
+		if !seen[column.Name] {
+			seen[column.Name] = true
+			selects = append(selects, column.Name)
+		}
 	}
 
-	return meta.Total, syncMeta.Error
+	return selects
 }
 
 func NewSyncer() *Syncer {
@@ -2,15 +2,27 @@ package syncer_test
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/enorith/gormdb"
+	"github.com/enorith/supports/collection"
 	"github.com/enorith/syncer"
 	"github.com/enorith/syncer/ds"
+	"github.com/go-co-op/gocron"
 	"github.com/joho/godotenv"
 	jsoniter "github.com/json-iterator/go"
 	"gorm.io/driver/mysql"
@@ -107,3 +119,394 @@ func TestSource(t *testing.T) {
 func TestInterval(t *testing.T) {
 	t.Log(time.ParseDuration("24h"))
 }
+
+func loadTasks(t *testing.T, content string) *syncer.Syncer {
+	var confs []syncer.SyncerTask
+	if e := jsoniter.Unmarshal([]byte(content), &confs); e != nil {
+		t.Fatal(e)
+	}
+
+	sy := syncer.NewSyncer()
+	if e := sy.AddTasks(confs...); e != nil {
+		t.Fatal(e)
+	}
+
+	return sy
+}
+
+// fileFixture writes files into a temp dir and registers the csv and jsonl datasources, returning the dir
+func fileFixture(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if e := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); e != nil {
+			t.Fatal(e)
+		}
+	}
+
+	ds.RegisterDatasource("csv", ds.FileRegister)
+	ds.RegisterDatasource("jsonl", ds.FileRegister)
+
+	return dir
+}
+
+// fileTasks loads tasks reading and writing the files of a fixture, %[1]s in tasks is its dir
+func fileTasks(t *testing.T, files map[string]string, tasks string) (*syncer.Syncer, string) {
+	t.Helper()
+	dir := fileFixture(t, files)
+
+	return loadTasks(t, fmt.Sprintf(tasks, dir)), dir
+}
+
+func TestSyncIntoDatasource(t *testing.T) {
+	sy, dir := fileTasks(t, map[string]string{
+		"users.csv":  "id,name,age\n1, a ,20\n2,b,30\n3,c,40\n",
+		"copy.jsonl": `{"uid":2,"name":"old"}` + "\n",
+	}, `[{
+		"id": "copy_users",
+		"source": "csv://%[1]s/users.csv",
+		"mapping": {"id": "uid", "name": "name|trim"},
+		"filters": [{"field": "age", "op": "<", "value": 40}],
+		"target": "jsonl://%[1]s/copy.jsonl",
+		"target_config": {"keys": ["uid"]},
+		"size": 1,
+		"workers": 1
+	}]`)
+
+	total, e := sy.DoSync("copy_users")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if total != 2 {
+		t.Fatalf("expected 2 rows, got %d", total)
+	}
+
+	target, _ := ds.Connect("jsonl://" + dir + "/copy.jsonl")
+	res, _ := target.List(ds.ListOption{Orders: []ds.ListOrder{{Field: "uid", Order: "asc"}}})
+	if res.Meta.Total != 2 || res.Data[0].(map[string]any)["name"] != "a" || res.Data[1].(map[string]any)["name"] != "b" {
+		t.Fatalf("unexpected target rows: %v", res.Data)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	sy, _ := fileTasks(t, map[string]string{
+		"users.csv": "id,full_name\n1,a\n",
+	}, `[{
+		"id": "drifted",
+		"source": "csv://%[1]s/users.csv",
+		"mapping": {"id": "uid", "name": "name"},
+		"target": "jsonl://%[1]s/copy.jsonl",
+		"drift_policy": "fail",
+		"size": 10,
+		"workers": 1
+	}]`)
+
+	drifts, e := sy.Validate("drifted")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if len(drifts) != 1 || drifts[0].Kind != syncer.DriftSourceMissing || drifts[0].Field != "name" {
+		t.Fatalf("unexpected drifts: %v", drifts)
+	}
+
+	var driftErr *syncer.DriftError
+	if _, e := sy.DoSync("drifted"); !errors.As(e, &driftErr) {
+		t.Fatalf("expected drift error, got %v", e)
+	}
+}
+
+func TestFanOut(t *testing.T) {
+	sy, dir := fileTasks(t, map[string]string{
+		"users.csv": "id,name\n1,a\n2,b\n3,c\n",
+	}, `[{
+		"id": "fan_out",
+		"source": "csv://%[1]s/users.csv",
+		"mapping": {"id": "uid", "name": "name"},
+		"targets": [
+			{"target": "jsonl://%[1]s/report.jsonl"},
+			{"target": "jsonl://%[1]s/search.jsonl", "mapping": {"name": "title"}},
+			{"target": "jsonl://%[1]s/missing/dir.jsonl"}
+		],
+		"size": 2,
+		"workers": 1,
+		"stop_on_error": true
+	}]`)
+
+	task, _ := sy.GetTask("fan_out")
+	run, e := sy.RunTask(task)
+	if e == nil {
+		t.Fatal("expected error of the missing directory target")
+	}
+
+	statuses := collection.Map(run.Targets, func(meta *syncer.SyncMeta) int {
+		return meta.Status
+	})
+	if !reflect.DeepEqual(statuses, []int{syncer.SyncStatusSuccess, syncer.SyncStatusSuccess, syncer.SyncStatusFailed}) {
+		t.Fatalf("unexpected target statuses: %v", statuses)
+	}
+
+	report, _ := ds.Connect("jsonl://" + dir + "/report.jsonl")
+	search, _ := ds.Connect("jsonl://" + dir + "/search.jsonl")
+	reportRows, _ := report.List(ds.ListOption{})
+	searchRows, _ := search.List(ds.ListOption{Orders: []ds.ListOrder{{Field: "title", Order: "asc"}}})
+	if reportRows.Meta.Total != 3 || searchRows.Meta.Total != 3 || searchRows.Data[0].(map[string]any)["title"] != "a" {
+		t.Fatalf("unexpected target rows: %v %v", reportRows.Data, searchRows.Data)
+	}
+}
+
+func TestRunGraph(t *testing.T) {
+	sy, _ := fileTasks(t, map[string]string{
+		"users.csv": "id,name\n1,a\n",
+	}, `[
+		{"id": "users", "source": "csv://%[1]s/users.csv", "mapping": {"id": "id"}, "target": "jsonl://%[1]s/users.jsonl", "size": 10, "workers": 1},
+		{"id": "orders", "depends_on": ["users"], "source": "jsonl://%[1]s/users.jsonl", "mapping": {"id": "user_id"}, "target": "jsonl://%[1]s/orders.jsonl", "size": 10, "workers": 1},
+		{"id": "broken", "source": "csv://%[1]s/missing.csv", "target": "jsonl://%[1]s/broken.jsonl", "size": 10, "workers": 1},
+		{"id": "after_broken", "depends_on": ["broken"], "source": "csv://%[1]s/users.csv", "target": "jsonl://%[1]s/after.jsonl", "size": 10, "workers": 1}
+	]`)
+
+	metas, e := sy.RunGraph()
+	if e == nil {
+		t.Fatal("expected error of the broken task")
+	}
+
+	statuses := map[string]int{}
+	for id, meta := range metas {
+		statuses[id] = meta.Status
+	}
+	want := map[string]int{
+		"users":        syncer.SyncStatusSuccess,
+		"orders":       syncer.SyncStatusSuccess,
+		"broken":       syncer.SyncStatusFailed,
+		"after_broken": syncer.SyncStatusSkipped,
+	}
+	if !reflect.DeepEqual(statuses, want) {
+		t.Fatalf("unexpected statuses: %v", statuses)
+	}
+
+	metas, e = sy.RunGraph("users")
+	if e != nil || len(metas) != 2 {
+		t.Fatalf("expected users and orders to run, got %v %v", metas, e)
+	}
+
+	e = sy.AddTasks(syncer.SyncerTask{ID: "users", DependsOn: []string{"orders"}})
+	if e == nil || !strings.Contains(e.Error(), "cycle") {
+		t.Fatalf("expected cycle error, got %v", e)
+	}
+
+	e = sy.AddTasks(syncer.SyncerTask{ID: "refunds", DependsOn: []string{"payments"}})
+	if e == nil || !strings.Contains(e.Error(), "unknown task payments") {
+		t.Fatalf("expected unknown dependency error, got %v", e)
+	}
+	if _, ok := sy.GetTask("refunds"); ok {
+		t.Fatal("expected refunds not added")
+	}
+
+	// unchecked tasks are checked when the graph runs
+	sy.AddTask(syncer.SyncerTask{ID: "users", DependsOn: []string{"orders"}})
+	if _, e := sy.RunGraph("users"); e == nil || !strings.Contains(e.Error(), "cycle") {
+		t.Fatalf("expected cycle error, got %v", e)
+	}
+}
+
+// selectsSource records the selects of its lists
+type selectsSource struct {
+	ds.Datasource
+	selects *[][]string
+}
+
+func (s selectsSource) List(opt ds.ListOption) (ds.ListResult, error) {
+	*s.selects = append(*s.selects, opt.Selects)
+	return s.Datasource.List(opt)
+}
+
+func (s selectsSource) Schema() ([]ds.Column, error) {
+	return s.Datasource.(ds.SchemaProvider).Schema()
+}
+
+func TestSourceSelects(t *testing.T) {
+	dir := fileFixture(t, map[string]string{"users.csv": "id,name,age\n1,a,20\n"})
+
+	var selects [][]string
+	ds.RegisterDatasource("selectscsv", func(u *url.URL) (ds.Datasource, error) {
+		u.Scheme = "csv"
+		source, e := ds.FileRegister(u)
+		return selectsSource{Datasource: source, selects: &selects}, e
+	})
+	syncer.RegisterValueResolver("age_label", func(value any, item map[string]any, args ...string) any {
+		return fmt.Sprint(item["age"])
+	})
+
+	for mapping, want := range map[string]string{
+		`{"id": "uid", "name": "name"}`:         "[id name]",
+		`{"id": "uid", "nickname": "nickname"}`: "[]",
+		`{"id": "uid|age_label"}`:               "[]",
+	} {
+		selects = nil
+		sy := loadTasks(t, fmt.Sprintf(`[{"id": "users", "source": "selectscsv://%[1]s/users.csv", "mapping": %s,
+			"target": "jsonl://%[1]s/users.jsonl", "size": 10, "workers": 1}]`, dir, mapping))
+		if _, e := sy.DoSync("users"); e != nil {
+			t.Fatal(e)
+		}
+
+		if len(selects) == 0 || fmt.Sprint(selects[len(selects)-1]) != want {
+			t.Fatalf("mapping %s: expected selects %s, got %v", mapping, want, selects)
+		}
+	}
+}
+
+func TestScheduleRoots(t *testing.T) {
+	sy := syncer.NewSyncer()
+	e := sy.AddTasks(
+		syncer.SyncerTask{ID: "users", Interval: "1h"},
+		syncer.SyncerTask{ID: "orders", DependsOn: []string{"users"}, Interval: "1h"},
+		syncer.SyncerTask{ID: "items", DependsOn: []string{"orders"}, Interval: "2d"},
+		syncer.SyncerTask{ID: "manual"},
+		syncer.SyncerTask{ID: "report", DependsOn: []string{"manual"}, Interval: "30m"},
+		syncer.SyncerTask{ID: "daily@eu", Group: "daily", Interval: "1d"},
+	)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	sch := gocron.NewScheduler(time.UTC)
+	sy.Schedule(sch)
+
+	var tags []string
+	for _, job := range sch.Jobs() {
+		tags = append(tags, job.Tags()...)
+	}
+	sort.Strings(tags)
+	if fmt.Sprint(tags) != "[syncer-group:daily syncer:daily@eu syncer:report syncer:users]" {
+		t.Fatalf("expected only tasks without scheduled upstream tasks scheduled, got %v", tags)
+	}
+}
+
+func TestSyncSequentialSource(t *testing.T) {
+	var requests atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cursor", func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+
+		start, _ := strconv.Atoi(r.URL.Query().Get("after"))
+		size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+		end := min(start+size, 7)
+
+		var items []map[string]any
+		for id := start + 1; id <= end; id++ {
+			items = append(items, map[string]any{"id": id})
+		}
+
+		next := ""
+		if end < 7 {
+			next = strconv.Itoa(end)
+		}
+		b, _ := jsoniter.Marshal(map[string]any{"items": items, "next": next})
+		w.Write(b)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	dir := fileFixture(t, nil)
+	ds.RegisterDatasource("http", ds.HTTPRegister)
+	ds.RegisterHTTPOptions("sequential", ds.HTTPOptions{
+		Pagination:  ds.PaginationCursor,
+		CursorParam: "after",
+		CursorPath:  "next",
+		RecordsPath: "items",
+	})
+
+	sy := loadTasks(t, fmt.Sprintf(`[{
+		"id": "cursor_users",
+		"source": "%s/cursor?ds_options=sequential",
+		"mapping": {"id": "id"},
+		"target": "jsonl://%s/users.jsonl",
+		"size": 2,
+		"workers": 4
+	}]`, server.URL, dir))
+
+	total, e := sy.DoSync("cursor_users")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if total != 7 {
+		t.Fatalf("expected 7 rows, got %d", total)
+	}
+
+	// 4 pages, each fetched once and none to count
+	if n := requests.Load(); n != 4 {
+		t.Fatalf("expected 4 requests, got %d", n)
+	}
+
+	target, _ := ds.Connect("jsonl://" + dir + "/users.jsonl")
+	meta, _ := target.ListMeta()
+	if meta.Total != 7 {
+		t.Fatalf("expected 7 target rows, got %d", meta.Total)
+	}
+}
+
+func TestDatasourceTargetRequiresUpserter(t *testing.T) {
+	endpoint, _ := url.Parse("http://localhost/users")
+	target := syncer.NewDatasourceTarget(ds.NewHTTP(endpoint, ds.HTTPOptions{}))
+
+	e := target.BeforeSync(targetConfig(t, map[string]any{"keys": []string{"id"}}), &syncer.SyncMeta{})
+	if e == nil || !strings.Contains(e.Error(), "upserts") {
+		t.Fatalf("expected keys to require an upserter, got %v", e)
+	}
+
+	if e := target.BeforeSync(targetConfig(t, map[string]any{}), &syncer.SyncMeta{}); e != nil {
+		t.Fatal(e)
+	}
+}
+
+// failingTarget fails writing the rows of a page holding failID
+type failingTarget struct {
+	failID string
+	after  *syncer.SyncMeta
+}
+
+func (ft *failingTarget) SyncFrom(conf syncer.TargetConfig, data []map[string]any, meta *syncer.SyncMeta) error {
+	for _, row := range data {
+		if ds.ValueString(row["id"]) == ft.failID {
+			return errors.New("write failed")
+		}
+	}
+
+	return nil
+}
+
+func (ft *failingTarget) BeforeSync(conf syncer.TargetConfig, meta *syncer.SyncMeta) error {
+	return nil
+}
+
+func (ft *failingTarget) AfterSync(conf syncer.TargetConfig, meta *syncer.SyncMeta) error {
+	ft.after = meta
+	return nil
+}
+
+func TestFailedPagesRecorded(t *testing.T) {
+	dir := fileFixture(t, map[string]string{
+		"users.csv": "id\n1\n2\n3\n",
+	})
+
+	target := &failingTarget{failID: "2"}
+	syncer.RegisterTarget("failing", target)
+
+	sy := loadTasks(t, fmt.Sprintf(`[{
+		"id": "partial",
+		"source": "csv://%s/users.csv",
+		"mapping": {"id": "id"},
+		"target": "failing",
+		"size": 1,
+		"workers": 2
+	}]`, dir))
+
+	task, _ := sy.GetTask("partial")
+	run, e := sy.RunTask(task)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if run.Status != syncer.SyncStatusSuccess || run.FailedPages != 1 || target.after.FailedPages != 1 || target.after.Complete() {
+		t.Fatalf("expected one failed page on a successful, incomplete run: %+v", run)
+	}
+}
@@ -3,6 +3,8 @@ package syncer
 import (
 	"errors"
 	"fmt"
+	"log"
+	"strings"
 	"sync"
 	"time"
 
@@ -11,6 +13,7 @@ import (
 	"github.com/enorith/syncer/ds"
 	jsoniter "github.com/json-iterator/go"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 var DefaultTimeFormat = "2006-01-02 15:04:05"
@@ -24,6 +27,10 @@ func (t TargetConfig) RawData() []byte {
 }
 
 func (t TargetConfig) Unmarshal(val interface{}) error {
+	if len(t.rawData) == 0 {
+		return nil
+	}
+
 	return jsoniter.Unmarshal(t.rawData, val)
 }
 
@@ -32,12 +39,25 @@ func (t *TargetConfig) UnmarshalJSON(row []byte) error {
 	return nil
 }
 
+func (t TargetConfig) MarshalJSON() ([]byte, error) {
+	if len(t.rawData) == 0 {
+		return []byte("null"), nil
+	}
+
+	return t.rawData, nil
+}
+
 type Target interface {
 	SyncFrom(conf TargetConfig, data []map[string]any, meta *SyncMeta) error
 	BeforeSync(conf TargetConfig, meta *SyncMeta) error
 	AfterSync(conf TargetConfig, meta *SyncMeta) error
 }
 
+// ConfigValidator is implemented by targets which can check a target config before a run
+type ConfigValidator interface {
+	ValidateConfig(conf TargetConfig) error
+}
+
 var (
 	SyncerTargets = make(map[string]Target)
 	mu            = new(sync.RWMutex)
@@ -66,10 +86,47 @@ type DBTargetConfig struct {
 	SyncTimeFmt     string `json:"sync_time_fmt"`
 	SyncStatusField string `json:"sync_status_field"`
 	MaxVersion      int    `json:"max_version"`
+
+	// DeleteMode hard, soft or archive rows not seen by a successful run, requires VersionField
+	DeleteMode     string `json:"delete_mode"`
+	DeletedAtField string `json:"deleted_at_field"`
+	ArchiveTable   string `json:"archive_table"`
+	// DeleteThreshold aborts the propagation when more than this percent of rows would be removed
+	DeleteThreshold float64 `json:"delete_threshold"`
+
+	// Strategy upsert (default), swap, which writes into <table>_shadow and renames it over the table on success,
+	// or scd2, which keeps changed rows as history closed by ValidToField
+	Strategy     string `json:"strategy"`
+	KeepPrevious bool   `json:"keep_previous"`
+
+	ValidFromField string `json:"valid_from_field"`
+	ValidToField   string `json:"valid_to_field"`
+	CurrentField   string `json:"current_field"`
+
+	// HashField stores a hash of the mapped fields, rows with a matching hash are only
+	// bumped to the current version and sync time, or skipped with SkipUnchanged, which keeps
+	// their version and so can not be combined with version based deletes or flags
+	HashField     string `json:"hash_field"`
+	SkipUnchanged bool   `json:"skip_unchanged"`
+
+	// WriteBatchSize rows per insert statement, capped so a statement stays within MaxPlaceholders
+	WriteBatchSize int `json:"write_batch_size"`
+	// Transaction none (default), page, which writes each page in a transaction,
+	// or run, which writes the whole run in one transaction committed by a successful AfterSync
+	Transaction    string `json:"transaction"`
+	IsolationLevel string `json:"isolation_level"`
+
+	// AutoMigrate creates the table, or adds missing columns, from SyncMeta.Columns and the sync mode columns
+	AutoMigrate bool `json:"auto_migrate"`
+	// DryRun prints the planned DDL and skips every write
+	DryRun bool `json:"dry_run"`
 }
 
 type DBTarget struct {
 	db *gorm.DB
+
+	mu   sync.Mutex
+	runs map[*SyncMeta]*runTx
 }
 
 func (db *DBTarget) SyncFrom(conf TargetConfig, data []map[string]any, meta *SyncMeta) error {
@@ -82,13 +139,8 @@ func (db *DBTarget) SyncFrom(conf TargetConfig, data []map[string]any, meta *Syn
 		return errors.New("[target] db table is required")
 	}
 
-	var opts []dbutil.UpsertOpt
-	if len(config.Uniques) > 0 {
-		opts = append(opts, dbutil.UpsertOptColumns(config.Uniques...))
-	}
-
-	if len(config.Updates) > 0 {
-		opts = append(opts, dbutil.UpsertOptUpdateColumns(config.Updates...))
+	if config.DryRun {
+		return nil
 	}
 
 	timeFmt := DefaultTimeFormat
@@ -97,7 +149,16 @@ func (db *DBTarget) SyncFrom(conf TargetConfig, data []map[string]any, meta *Syn
 		timeFmt = config.SyncTimeFmt
 	}
 
-	tx := db.newSession()
+	var compares []string
+	if config.Strategy == StrategySCD2 {
+		compares = config.compareColumns(data)
+	}
+
+	if config.HashField != "" {
+		for _, row := range data {
+			row[config.HashField] = RowHash(row, config.HashField)
+		}
+	}
 
 	if config.VersionField != "" || config.SyncTimeField != "" {
 		data = collection.Map(data, func(row map[string]any) map[string]any {
@@ -114,23 +175,120 @@ func (db *DBTarget) SyncFrom(conf TargetConfig, data []map[string]any, meta *Syn
 		})
 	}
 
-	return tx.Table(config.Table).Scopes(dbutil.WithUpsert(opts...)).Create(data).Error
+	if config.DeleteMode == DeleteModeSoft {
+		for _, row := range data {
+			row[config.deletedAtField()] = nil
+		}
+	}
+
+	return db.write(config, meta, func(tx *gorm.DB) error {
+		if config.Strategy == StrategySCD2 {
+			return db.syncSCD2(tx, config, data, compares, meta, now.Format(timeFmt))
+		}
+
+		data := data
+		if config.HashField != "" && config.Strategy != StrategySwap {
+			var e error
+			if data, e = db.skipUnchanged(tx, config, data, meta); e != nil || len(data) == 0 {
+				return e
+			}
+		}
+
+		opts, e := upsertOpts(dialectOf(tx), config, data)
+		if e != nil {
+			return e
+		}
+
+		return createInBatches(tx, config, config.writeTable(), data, dbutil.WithUpsert(opts...))
+	})
+}
+
+// checkConfig checks the options of a config which do not need the database
+func checkConfig(config DBTargetConfig) error {
+	if e := checkDeleteMode(config); e != nil {
+		return e
+	}
+
+	if config.HashField != "" {
+		if e := checkHash(config); e != nil {
+			return e
+		}
+	}
+
+	if e := checkTransaction(config); e != nil {
+		return e
+	}
+
+	switch config.Strategy {
+	case "", StrategyUpsert:
+	case StrategySwap:
+		return checkSwap(config)
+	case StrategySCD2:
+		return checkSCD2(config)
+	default:
+		return fmt.Errorf("[target] unknown strategy: %s", config.Strategy)
+	}
+
+	return nil
+}
+
+func (db *DBTarget) ValidateConfig(conf TargetConfig) error {
+	var config DBTargetConfig
+	if e := conf.Unmarshal(&config); e != nil {
+		return e
+	}
+
+	if config.Table == "" {
+		return errors.New("[target] table is required")
+	}
+
+	return checkConfig(config)
 }
 
 func (db *DBTarget) BeforeSync(conf TargetConfig, meta *SyncMeta) error {
 	var config DBTargetConfig
 	conf.Unmarshal(&config)
 
+	if e := checkConfig(config); e != nil {
+		return e
+	}
+
+	if config.AutoMigrate {
+		if e := db.autoMigrate(conf, config, meta); e != nil {
+			return e
+		}
+	}
+
+	if config.DryRun {
+		return nil
+	}
+
+	if config.Strategy != StrategySCD2 {
+		if e := checkConflictTarget(db.newSession(), config.Table, config.Uniques); e != nil {
+			return e
+		}
+	}
+
 	if config.VersionField != "" {
 		tx := db.newSession()
 
 		var version int
 		model := ds.MapModel(config.Table)
-		e := tx.Model(&model).Select(fmt.Sprintf("MAX(%s)", config.VersionField)).Table(config.Table).Scan(&version).Error
+		e := tx.Model(&model).Select(fmt.Sprintf("COALESCE(MAX(%s), 0)", quoteIdent(tx, config.VersionField))).Table(config.Table).Scan(&version).Error
 
 		meta.Version = version + 1
 
-		return e
+		if e != nil {
+			return e
+		}
+	}
+
+	if config.Strategy == StrategySwap {
+		return db.createShadow(config)
+	}
+
+	if config.Transaction == TransactionRun {
+		return db.beginRun(config, meta)
 	}
 
 	return nil
@@ -140,8 +298,55 @@ func (db *DBTarget) AfterSync(conf TargetConfig, meta *SyncMeta) error {
 	var config DBTargetConfig
 	conf.Unmarshal(&config)
 
+	if config.DryRun {
+		return nil
+	}
+
+	if config.Strategy == StrategySwap {
+		if meta.Status == SyncStatusSuccess {
+			return db.swapShadow(config, meta)
+		}
+
+		return db.dropShadow(config)
+	}
+
+	if config.Transaction != TransactionRun {
+		return db.afterSync(db.newSession(), config, meta)
+	}
+
+	run, ok := db.runTx(meta)
+	if !ok {
+		return errors.New("[target] run transaction is not open")
+	}
+
+	var e error
+	if meta.Status == SyncStatusSuccess && run.failed == nil {
+		run.mu.Lock()
+		e = db.afterSync(run.tx, config, meta)
+		run.mu.Unlock()
+	}
+
+	return db.endRun(meta, e)
+}
+
+func (db *DBTarget) afterSync(tx *gorm.DB, config DBTargetConfig, meta *SyncMeta) error {
+	if config.Strategy == StrategySCD2 && meta.Status == SyncStatusSuccess {
+		if !meta.Complete() {
+			log.Printf("[target] closing missing keys of %s skipped, %d pages of the run failed", config.Table, meta.FailedPages)
+		} else if e := db.closeMissing(tx, config, meta); e != nil {
+			return e
+		}
+	}
+
+	if config.DeleteMode != "" && meta.Status == SyncStatusSuccess {
+		if !meta.Complete() {
+			log.Printf("[target] delete propagation of %s skipped, %d pages of the run failed", config.Table, meta.FailedPages)
+		} else if e := db.propagateDeletes(tx, config, meta); e != nil {
+			return e
+		}
+	}
+
 	if config.SyncStatusField != "" && config.VersionField != "" {
-		tx := db.newSession()
 		model := ds.MapModel(config.Table)
 		e := tx.Model(&model).Table(config.Table).Where(fmt.Sprintf("%s < ?", config.VersionField), meta.Version).Update(config.SyncStatusField, 0).Error
 
@@ -151,8 +356,6 @@ func (db *DBTarget) AfterSync(conf TargetConfig, meta *SyncMeta) error {
 	}
 
 	if config.VersionField != "" && config.MaxVersion > 0 {
-		tx := db.newSession()
-
 		model := ds.MapModel(config.Table)
 
 		e := tx.Where(fmt.Sprintf("%s < ? AND %s > ?", config.VersionField, config.VersionField), meta.Version-config.MaxVersion, 0).Table(config.Table).Delete(&model).Error
@@ -164,6 +367,110 @@ func (db *DBTarget) AfterSync(conf TargetConfig, meta *SyncMeta) error {
 	return nil
 }
 
+// TargetSchema columns of the table, nil with AutoMigrate as missing columns are added by the migration
+func (db *DBTarget) TargetSchema(conf TargetConfig) ([]ds.Column, error) {
+	var config DBTargetConfig
+	conf.Unmarshal(&config)
+
+	if config.AutoMigrate {
+		return nil, nil
+	}
+
+	if config.Table == "" {
+		return nil, errors.New("[target] db table is required")
+	}
+
+	return ds.NewDB(db.newSession(), ds.NewDBConfig{Table: config.Table, Model: ds.MapModel(config.Table)}).Schema()
+}
+
+// upsertOpts builds the upsert clause for the dialect, ON CONFLICT dialects
+// need the unique columns as conflict target and DO NOTHING without updates
+func upsertOpts(dialect string, config DBTargetConfig, data []map[string]any) ([]dbutil.UpsertOpt, error) {
+	var opts []dbutil.UpsertOpt
+	if len(config.Uniques) > 0 {
+		opts = append(opts, dbutil.UpsertOptColumns(config.Uniques...))
+	}
+
+	updates := config.updateColumns(data)
+	if len(updates) > 0 {
+		opts = append(opts, dbutil.UpsertOptUpdateColumns(updates...))
+	}
+
+	if requiresConflictTarget(dialect) {
+		if len(updates) > 0 && len(config.Uniques) == 0 {
+			return nil, fmt.Errorf("[target] %s upsert requires uniques when updates are set", dialect)
+		}
+
+		if len(config.Uniques) > 0 && len(updates) == 0 {
+			opts = append(opts, func(on clause.OnConflict) clause.OnConflict {
+				on.DoNothing = true
+				return on
+			})
+		}
+	}
+
+	return opts, nil
+}
+
+// updateColumns adds the columns the sync modes rely on to the configured updates,
+// the row hash with change detection and the version and sync columns with delete
+// propagation, so seen rows are not taken as deleted
+func (config DBTargetConfig) updateColumns(data []map[string]any) []string {
+	updates := append([]string{}, config.Updates...)
+
+	var extra []string
+	if config.HashField != "" {
+		if len(updates) == 0 {
+			updates = config.compareColumns(data)
+		}
+		extra = append(extra, config.HashField, config.VersionField, config.SyncTimeField, config.SyncStatusField)
+	}
+
+	if config.DeleteMode != "" {
+		extra = append(extra, config.VersionField, config.SyncTimeField, config.SyncStatusField)
+		if config.DeleteMode == DeleteModeSoft {
+			extra = append(extra, config.deletedAtField())
+		}
+	}
+
+	for _, field := range extra {
+		if field != "" && !collection.Contains(updates, field) {
+			updates = append(updates, field)
+		}
+	}
+
+	return updates
+}
+
+// keyFields natural key of the rows, the uniques without the version field
+func (config DBTargetConfig) keyFields() []string {
+	return collection.Filter(config.Uniques, func(field string) bool {
+		return field != config.VersionField
+	})
+}
+
+// whereKeys filters rows matching the keys of rows, with a row value IN for composite keys
+func whereKeys(tx *gorm.DB, keys []string, rows []map[string]any) *gorm.DB {
+	if len(keys) == 1 {
+		values := collection.Map(rows, func(row map[string]any) any {
+			return row[keys[0]]
+		})
+
+		return tx.Where(fmt.Sprintf("%s IN ?", quoteIdent(tx, keys[0])), values)
+	}
+
+	columns := collection.Map(keys, func(key string) string {
+		return quoteIdent(tx, key)
+	})
+	values := collection.Map(rows, func(row map[string]any) []any {
+		return collection.Map(keys, func(key string) any {
+			return row[key]
+		})
+	})
+
+	return tx.Where(fmt.Sprintf("(%s) IN ?", strings.Join(columns, ", ")), values)
+}
+
 func (db *DBTarget) newSession() *gorm.DB {
 	return db.db.Session(&gorm.Session{NewDB: true})
 }
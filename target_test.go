@@ -0,0 +1,348 @@
+package syncer_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/enorith/syncer"
+	"github.com/enorith/syncer/ds"
+	embeddedpostgres "github.com/fergusstrange/embedded-postgres"
+	jsoniter "github.com/json-iterator/go"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type sqlRecorder struct {
+	logger.Interface
+	mu   sync.Mutex
+	sqls []string
+}
+
+func (r *sqlRecorder) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	sql, _ := fc()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sqls = append(r.sqls, sql)
+}
+
+func (r *sqlRecorder) LogMode(logger.LogLevel) logger.Interface {
+	return r
+}
+
+// dryRunPool connections of dry run sessions, transactions begin and commit without a database
+type dryRunPool struct{}
+
+func (dryRunPool) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return nil, errors.New("dry run")
+}
+
+func (dryRunPool) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return nil, errors.New("dry run")
+}
+
+func (dryRunPool) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return nil, errors.New("dry run")
+}
+
+func (dryRunPool) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return nil
+}
+
+func (p dryRunPool) BeginTx(ctx context.Context, opts *sql.TxOptions) (gorm.ConnPool, error) {
+	return &dryRunTx{p}, nil
+}
+
+type dryRunTx struct {
+	dryRunPool
+}
+
+func (*dryRunTx) Commit() error {
+	return nil
+}
+
+func (*dryRunTx) Rollback() error {
+	return nil
+}
+
+// dryRunDB a dry run session of a postgres or mysql dialect recording its statements,
+// queries scan the results in order, Count takes an int64
+func dryRunDB(t *testing.T, dialect string, results ...any) (*gorm.DB, *sqlRecorder) {
+	var dialector gorm.Dialector = postgres.New(postgres.Config{Conn: dryRunPool{}})
+	if dialect == syncer.DialectMySQL {
+		dialector = mysql.New(mysql.Config{Conn: dryRunPool{}, SkipInitializeWithVersion: true})
+	}
+
+	recorder := &sqlRecorder{Interface: logger.Discard}
+	db, e := gorm.Open(dialector, &gorm.Config{
+		DryRun:                 true,
+		SkipDefaultTransaction: true,
+		DisableAutomaticPing:   true,
+		Logger:                 recorder,
+	})
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	var mu sync.Mutex
+	db.Callback().Query().After("gorm:query").Register("test:results", func(tx *gorm.DB) {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(results) == 0 {
+			return
+		}
+
+		dest := reflect.ValueOf(tx.Statement.Dest)
+		result := reflect.ValueOf(results[0])
+		if dest.Kind() != reflect.Pointer || dest.Elem().Type() != result.Type() {
+			return
+		}
+
+		// Count keeps the scanned count of a query affecting one row
+		dest.Elem().Set(result)
+		tx.RowsAffected = 1
+		results = results[1:]
+	})
+
+	return db, recorder
+}
+
+func (r *sqlRecorder) statements() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]string{}, r.sqls...)
+}
+
+func targetConfig(t *testing.T, conf map[string]any) syncer.TargetConfig {
+	b, _ := jsoniter.Marshal(conf)
+	var tc syncer.TargetConfig
+	if e := tc.UnmarshalJSON(b); e != nil {
+		t.Fatal(e)
+	}
+
+	return tc
+}
+
+func TestPostgresUpsertSQL(t *testing.T) {
+	db, recorder := dryRunDB(t, syncer.DialectPostgres)
+	target := syncer.NewDBTarget(db)
+	conf := targetConfig(t, map[string]any{
+		"table":   "users_copy",
+		"uniques": []string{"username", "version"},
+		"updates": []string{"name", "password"},
+	})
+
+	e := target.SyncFrom(conf, []map[string]any{{"username": "nerio", "name": "Nerio", "password": "x"}}, &syncer.SyncMeta{Version: 1})
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	sql := strings.Join(recorder.statements(), "\n")
+	want := `ON CONFLICT ("username","version") DO UPDATE SET "name"="excluded"."name","password"="excluded"."password"`
+	if !strings.Contains(sql, want) {
+		t.Fatalf("unexpected upsert sql: %s", sql)
+	}
+
+	noUniques := targetConfig(t, map[string]any{
+		"table":   "users_copy",
+		"updates": []string{"name"},
+	})
+	if e := target.SyncFrom(noUniques, []map[string]any{{"name": "Nerio"}}, &syncer.SyncMeta{}); e == nil {
+		t.Fatal("expected error for updates without uniques")
+	}
+}
+
+func TestWriteBatchSize(t *testing.T) {
+	db, recorder := dryRunDB(t, syncer.DialectPostgres)
+	target := syncer.NewDBTarget(db)
+	conf := targetConfig(t, map[string]any{"table": "users_copy", "write_batch_size": 2})
+
+	rows := []map[string]any{{"id": 1}, {"id": 2}, {"id": 3}}
+	if e := target.SyncFrom(conf, rows, &syncer.SyncMeta{}); e != nil {
+		t.Fatal(e)
+	}
+
+	if sqls := recorder.statements(); len(sqls) != 2 {
+		t.Fatalf("expected 2 insert statements, got %d: %v", len(sqls), sqls)
+	}
+
+	if e := target.BeforeSync(targetConfig(t, map[string]any{"table": "users_copy", "transaction": "batch"}), &syncer.SyncMeta{}); e == nil {
+		t.Fatal("expected error for unknown transaction")
+	}
+}
+
+func TestMigrationPlan(t *testing.T) {
+	db, _ := dryRunDB(t, syncer.DialectPostgres)
+	target := syncer.NewDBTarget(db)
+	conf := targetConfig(t, map[string]any{
+		"table":           "users_copy",
+		"uniques":         []string{"username"},
+		"version_field":   "version",
+		"sync_time_field": "sync_at",
+	})
+
+	plan, e := target.MigrationPlan(conf, []ds.Column{{Name: "username", Type: ds.TypeString, Length: 64}, {Name: "age", Type: ds.TypeInt}})
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	want := []string{
+		`CREATE TABLE "users_copy" ("username" VARCHAR(64), "age" BIGINT, "version" BIGINT, "sync_at" TIMESTAMP)`,
+		`CREATE UNIQUE INDEX "idx_users_copy_username" ON "users_copy" ("username")`,
+	}
+	if strings.Join(plan, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected plan: %v", plan)
+	}
+}
+
+func TestMigrationPlanTypes(t *testing.T) {
+	columns := []ds.Column{
+		{Name: "price", Type: ds.TypeDecimal, Precision: 12, Scale: 2},
+		{Name: "amount", Type: ds.TypeDecimal},
+		{Name: "born_on", Type: ds.TypeDate},
+		{Name: "opens_at", Type: ds.TypeTimeOfDay},
+	}
+
+	db, _ := dryRunDB(t, syncer.DialectPostgres)
+	plan, e := syncer.NewDBTarget(db).MigrationPlan(targetConfig(t, map[string]any{"table": "prices"}), columns)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	want := `CREATE TABLE "prices" ("price" NUMERIC(12,2), "amount" NUMERIC, "born_on" DATE, "opens_at" TIME)`
+	if len(plan) == 0 || plan[0] != want {
+		t.Fatalf("unexpected plan: %v", plan)
+	}
+}
+
+// postgresDB connects to PG_DSN, or to a postgres started for the test, the binaries are downloaded
+// to the module cache on the first run
+func postgresDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	loadEnv()
+
+	dsn := os.Getenv("PG_DSN")
+	if dsn == "" {
+		listener, e := net.Listen("tcp", "127.0.0.1:0")
+		if e != nil {
+			t.Fatal(e)
+		}
+		port := uint32(listener.Addr().(*net.TCPAddr).Port)
+		listener.Close()
+
+		cache, e := os.UserCacheDir()
+		if e != nil {
+			t.Fatal(e)
+		}
+
+		dir := t.TempDir()
+		pg := embeddedpostgres.NewDatabase(embeddedpostgres.DefaultConfig().
+			Port(port).
+			CachePath(filepath.Join(cache, "embedded-postgres")).
+			RuntimePath(filepath.Join(dir, "runtime")).
+			DataPath(filepath.Join(dir, "data")).
+			Logger(io.Discard))
+		if e := pg.Start(); e != nil {
+			t.Fatalf("start postgres: %v", e)
+		}
+		t.Cleanup(func() {
+			pg.Stop()
+		})
+
+		dsn = fmt.Sprintf("host=127.0.0.1 port=%d user=postgres password=postgres dbname=postgres sslmode=disable", port)
+	}
+
+	db, e := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	return db
+}
+
+func TestPostgresTarget(t *testing.T) {
+	db := postgresDB(t)
+
+	table := fmt.Sprintf("syncer_pg_%d", time.Now().UnixNano())
+	e := db.Exec(fmt.Sprintf(`CREATE TABLE %s (id SERIAL PRIMARY KEY, username VARCHAR(64), name VARCHAR(64), version INT, sync_at VARCHAR(32), sync_status INT)`, table)).Error
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer db.Exec("DROP TABLE " + table)
+
+	target := syncer.NewDBTarget(db)
+	conf := targetConfig(t, map[string]any{
+		"table":             table,
+		"uniques":           []string{"username"},
+		"updates":           []string{"name", "version", "sync_at", "sync_status"},
+		"version_field":     "version",
+		"sync_time_field":   "sync_at",
+		"sync_status_field": "sync_status",
+	})
+
+	meta := &syncer.SyncMeta{Total: 2}
+	if e := target.BeforeSync(conf, meta); e == nil || !strings.Contains(e.Error(), "unique index") {
+		t.Fatalf("expected missing unique index error, got %v", e)
+	}
+
+	if e := db.Exec(fmt.Sprintf("CREATE UNIQUE INDEX ON %s (username)", table)).Error; e != nil {
+		t.Fatal(e)
+	}
+
+	for run := 1; run <= 2; run++ {
+		meta := &syncer.SyncMeta{Total: 2}
+		if e := target.BeforeSync(conf, meta); e != nil {
+			t.Fatal(e)
+		}
+		if meta.Version != run {
+			t.Fatalf("expected version %d, got %d", run, meta.Version)
+		}
+
+		rows := []map[string]any{{"username": "a", "name": fmt.Sprintf("A%d", run)}}
+		if run == 1 {
+			rows = append(rows, map[string]any{"username": "b", "name": "B"})
+		}
+		if e := target.SyncFrom(conf, rows, meta); e != nil {
+			t.Fatal(e)
+		}
+
+		meta.Status = syncer.SyncStatusSuccess
+		if e := target.AfterSync(conf, meta); e != nil {
+			t.Fatal(e)
+		}
+	}
+
+	var stale int64
+	db.Table(table).Where("sync_status = 0").Count(&stale)
+	if stale != 1 {
+		t.Fatalf("expected 1 stale row, got %d", stale)
+	}
+
+	var upserted struct {
+		Name    string
+		Version int
+	}
+	db.Table(table).Where("username = ?", "a").Take(&upserted)
+	if upserted.Name != "A2" || upserted.Version != 2 {
+		t.Fatalf("expected row a upserted by run 2, got %+v", upserted)
+	}
+
+	var count int64
+	db.Table(table).Count(&count)
+	if count != 2 {
+		t.Fatalf("expected 2 rows, got %d", count)
+	}
+}
@@ -0,0 +1,286 @@
+package syncer
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/enorith/syncer/ds"
+	jsoniter "github.com/json-iterator/go"
+)
+
+var templateParam = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+
+// Tenant a tenant a template is expanded for, Params fill the {{name}} placeholders besides {{tenant}}
+type Tenant struct {
+	ID     string
+	Params map[string]any
+}
+
+// TenantProvider lists the tenants of a template
+type TenantProvider interface {
+	Tenants() ([]Tenant, error)
+}
+
+// StaticTenants tenants listed in the config, ids or objects of params with an id
+type StaticTenants []any
+
+func (st StaticTenants) Tenants() ([]Tenant, error) {
+	tenants := make([]Tenant, len(st))
+	for i, item := range st {
+		if params, ok := item.(map[string]any); ok {
+			tenant, e := tenantOf(params, "id")
+			if e != nil {
+				return nil, e
+			}
+			tenants[i] = tenant
+			continue
+		}
+
+		tenants[i] = Tenant{ID: ds.ValueString(item)}
+	}
+
+	return tenants, nil
+}
+
+// TenantPageSize tenants listed per page of a tenant datasource
+var TenantPageSize int64 = 500
+
+// DatasourceTenants tenants queried from a datasource, the id is read from Field and the other
+// fields of a row are params
+type DatasourceTenants struct {
+	Source  string
+	Filters []ds.ListFilter
+	Field   string
+}
+
+func (dt DatasourceTenants) Tenants() ([]Tenant, error) {
+	source, e := ds.Connect(dt.Source)
+	if e != nil {
+		return nil, e
+	}
+
+	field := dt.Field
+	if field == "" {
+		field = "id"
+	}
+
+	var tenants []Tenant
+	for page := int64(1); ; page++ {
+		result, e := source.List(ds.ListOption{
+			Page:        page,
+			Limit:       TenantPageSize,
+			WithoutMeta: true,
+			Filters:     dt.Filters,
+			Orders:      []ds.ListOrder{{Field: field, Order: "asc"}},
+		})
+		if e != nil {
+			return nil, e
+		}
+
+		for _, data := range result.Data {
+			row, ok := data.(map[string]any)
+			if !ok {
+				return nil, errors.New("[syncer] tenant source requires map rows")
+			}
+
+			tenant, e := tenantOf(row, field)
+			if e != nil {
+				return nil, e
+			}
+			tenants = append(tenants, tenant)
+		}
+
+		if int64(len(result.Data)) < TenantPageSize {
+			return tenants, nil
+		}
+	}
+}
+
+func tenantOf(params map[string]any, field string) (Tenant, error) {
+	id := ds.ValueString(params[field])
+	if id == "" {
+		return Tenant{}, fmt.Errorf("[syncer] tenant without %s: %v", field, params)
+	}
+
+	return Tenant{ID: id, Params: params}, nil
+}
+
+// TenantConfig the tenants of a template, a static List or the rows of Source
+type TenantConfig struct {
+	List    []any           `json:"list"`
+	Source  string          `json:"source"`
+	Filters []ds.ListFilter `json:"filters"`
+	Field   string          `json:"field"`
+}
+
+func (tc TenantConfig) Provider() (TenantProvider, error) {
+	switch {
+	case tc.Source != "":
+		return DatasourceTenants{Source: tc.Source, Filters: tc.Filters, Field: tc.Field}, nil
+	case len(tc.List) > 0:
+		return StaticTenants(tc.List), nil
+	}
+
+	return nil, errors.New("[syncer] tenants require list or source")
+}
+
+// TaskTemplate a task expanded once per tenant into tasks identified as id@tenant, {{tenant}} and
+// the params of the tenant are replaced in every string of the task, such as source, filters and target_config
+type TaskTemplate struct {
+	SyncerTask
+	Tenants TenantConfig `json:"tenants"`
+}
+
+// Expand the tasks of the tenants, grouped by the template id
+func (tpl TaskTemplate) Expand(tenants []Tenant) ([]SyncerTask, error) {
+	if tpl.ID == "" {
+		return nil, errors.New("[syncer] template id is required")
+	}
+
+	raw, e := jsoniter.Marshal(tpl.SyncerTask)
+	if e != nil {
+		return nil, e
+	}
+
+	tasks := make([]SyncerTask, 0, len(tenants))
+	seen := make(map[string]bool, len(tenants))
+	for _, tenant := range tenants {
+		if seen[tenant.ID] {
+			return nil, fmt.Errorf("[syncer] template %s: duplicate tenant %s", tpl.ID, tenant.ID)
+		}
+		seen[tenant.ID] = true
+
+		expanded, e := expandTemplate(raw, tenant)
+		if e != nil {
+			return nil, fmt.Errorf("[syncer] template %s, tenant %s: %w", tpl.ID, tenant.ID, e)
+		}
+
+		var task SyncerTask
+		if e := jsoniter.Unmarshal(expanded, &task); e != nil {
+			return nil, e
+		}
+		task.ID = TenantTaskID(tpl.ID, tenant.ID)
+		task.Group, task.Tenant = tpl.ID, tenant.ID
+
+		tasks = append(tasks, task)
+	}
+
+	return tasks, nil
+}
+
+// expandTemplate replaces the placeholders of the json of a task, unknown params fail
+func expandTemplate(raw []byte, tenant Tenant) ([]byte, error) {
+	var missing []string
+	expanded := templateParam.ReplaceAllFunc(raw, func(placeholder []byte) []byte {
+		name := string(templateParam.FindSubmatch(placeholder)[1])
+
+		var value any
+		if name == "tenant" {
+			value = tenant.ID
+		} else if v, ok := tenant.Params[name]; ok {
+			value = v
+		} else {
+			missing = append(missing, name)
+			return placeholder
+		}
+
+		// the value is escaped as the content of a json string
+		quoted, _ := jsoniter.Marshal(ds.ValueString(value))
+		return quoted[1 : len(quoted)-1]
+	})
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("unknown template params: %s", strings.Join(missing, ", "))
+	}
+
+	return expanded, nil
+}
+
+// TenantTaskID the id of the task of a template for a tenant
+func TenantTaskID(templateID, tenant string) string {
+	return templateID + "@" + tenant
+}
+
+// AddTemplate expands a template for its tenants and adds the tasks as a group, tasks of tenants
+// no longer listed are removed from the group
+func (s *Syncer) AddTemplate(tpl TaskTemplate) ([]string, error) {
+	provider, e := tpl.Tenants.Provider()
+	if e != nil {
+		return nil, e
+	}
+
+	tenants, e := provider.Tenants()
+	if e != nil {
+		return nil, e
+	}
+
+	tasks, e := tpl.Expand(tenants)
+	if e != nil {
+		return nil, e
+	}
+
+	ids := make([]string, len(tasks))
+	for i, task := range tasks {
+		ids[i] = task.ID
+	}
+
+	return ids, s.replaceGroup(tpl.ID, tasks)
+}
+
+// replaceGroup sets the tasks of a group, nothing changes when they make a dependency cycle
+func (s *Syncer) replaceGroup(group string, tasks []SyncerTask) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	merged := make(map[string]SyncerTask, len(s.tasks)+len(tasks))
+	for id, task := range s.tasks {
+		if task.Group != group {
+			merged[id] = task
+		}
+	}
+	for _, task := range tasks {
+		merged[task.ID] = task
+	}
+
+	if e := checkDependencies(merged); e != nil {
+		return e
+	}
+
+	s.tasks = merged
+
+	return nil
+}
+
+// GroupTasks the ids of the tasks of a group in order
+func (s *Syncer) GroupTasks(group string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var ids []string
+	for id, task := range s.tasks {
+		if task.Group == group {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	return ids
+}
+
+// RunGroup runs the tasks of a group and the tasks depending on them as a graph
+func (s *Syncer) RunGroup(group string) (map[string]*SyncMeta, error) {
+	ids := s.GroupTasks(group)
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("[syncer] group not found: %s", group)
+	}
+
+	return s.RunGraph(ids...)
+}
+
+// RemoveGroup removes the tasks of a group
+func (s *Syncer) RemoveGroup(group string) {
+	s.replaceGroup(group, nil)
+}
@@ -0,0 +1,93 @@
+package syncer_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/enorith/syncer"
+	"github.com/enorith/syncer/ds"
+	jsoniter "github.com/json-iterator/go"
+)
+
+func TestTaskTemplate(t *testing.T) {
+	dir := fileFixture(t, map[string]string{
+		"tenants.csv":       "code,region\nshop_a,eu\nshop_b,us\n",
+		"orders_shop_a.csv": "id,region\n1,eu\n2,us\n3,eu\n",
+		"orders_shop_b.csv": "id,region\n4,us\n",
+	})
+
+	var tpl syncer.TaskTemplate
+	e := jsoniter.Unmarshal([]byte(fmt.Sprintf(`{
+		"id": "sync_orders",
+		"source": "csv://%[1]s/orders_{{tenant}}.csv",
+		"filters": [{"field": "region", "op": "=", "value": "{{ region }}"}],
+		"mapping": {"id": "id"},
+		"target": "jsonl://%[1]s/out_{{tenant}}.jsonl",
+		"target_config": {"keys": ["id"]},
+		"tenants": {"source": "csv://%[1]s/tenants.csv", "field": "code"},
+		"size": 10,
+		"workers": 1
+	}`, dir)), &tpl)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	sy := syncer.NewSyncer()
+	ids, e := sy.AddTemplate(tpl)
+	if e != nil {
+		t.Fatal(e)
+	}
+	if fmt.Sprint(ids) != "[sync_orders@shop_a sync_orders@shop_b]" {
+		t.Fatalf("unexpected tasks: %v", ids)
+	}
+
+	task, _ := sy.GetTask("sync_orders@shop_b")
+	if task.Group != "sync_orders" || task.Tenant != "shop_b" || task.Filters[0].Value != "us" {
+		t.Fatalf("unexpected task: %+v", task)
+	}
+
+	if _, e := sy.RunGroup("sync_orders"); e != nil {
+		t.Fatal(e)
+	}
+
+	for tenant, want := range map[string]int64{"shop_a": 2, "shop_b": 1} {
+		target, _ := ds.Connect(fmt.Sprintf("jsonl://%s/out_%s.jsonl", dir, tenant))
+		meta, _ := target.ListMeta()
+		if meta.Total != want {
+			t.Fatalf("tenant %s: expected %d rows, got %d", tenant, want, meta.Total)
+		}
+	}
+
+	tpl.Tenants = syncer.TenantConfig{List: []any{map[string]any{"id": "shop_a", "region": "eu"}}}
+	if _, e := sy.AddTemplate(tpl); e != nil {
+		t.Fatal(e)
+	}
+	if ids := sy.GroupTasks("sync_orders"); fmt.Sprint(ids) != "[sync_orders@shop_a]" {
+		t.Fatalf("unexpected tasks after the tenants changed: %v", ids)
+	}
+
+	tpl.Tenants = syncer.TenantConfig{List: []any{"shop_c"}}
+	if _, e := sy.AddTemplate(tpl); e == nil {
+		t.Fatal("expected an unknown region param to fail")
+	}
+}
+
+func TestDatasourceTenantsPages(t *testing.T) {
+	dir := fileFixture(t, map[string]string{"tenants.csv": "code\nshop_e\nshop_a\nshop_d\nshop_b\nshop_c\n"})
+
+	defer func(size int64) { syncer.TenantPageSize = size }(syncer.TenantPageSize)
+	syncer.TenantPageSize = 2
+
+	tenants, e := syncer.DatasourceTenants{Source: "csv://" + dir + "/tenants.csv", Field: "code"}.Tenants()
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	ids := make([]string, len(tenants))
+	for i, tenant := range tenants {
+		ids[i] = tenant.ID
+	}
+	if fmt.Sprint(ids) != "[shop_a shop_b shop_c shop_d shop_e]" {
+		t.Fatalf("expected the tenants of every page, got %v", ids)
+	}
+}
@@ -0,0 +1,108 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// RowTransformer runs on each mapped row before it is written, returning no rows drops it,
+// several rows explode it
+type RowTransformer interface {
+	Transform(ctx context.Context, row map[string]any) ([]map[string]any, error)
+}
+
+// PageTransformer is implemented by transformers which handle the rows of a page at once
+type PageTransformer interface {
+	TransformPage(ctx context.Context, rows []map[string]any) ([]map[string]any, error)
+}
+
+// TransformerFunc a function as RowTransformer
+type TransformerFunc func(ctx context.Context, row map[string]any) ([]map[string]any, error)
+
+func (f TransformerFunc) Transform(ctx context.Context, row map[string]any) ([]map[string]any, error) {
+	return f(ctx, row)
+}
+
+var (
+	rowTransformers = make(map[string]RowTransformer)
+	transformerMu   sync.RWMutex
+)
+
+func RegisterTransformer(name string, transformer RowTransformer) {
+	transformerMu.Lock()
+	defer transformerMu.Unlock()
+	rowTransformers[name] = transformer
+}
+
+func GetTransformer(name string) (RowTransformer, bool) {
+	transformerMu.RLock()
+	defer transformerMu.RUnlock()
+	transformer, ok := rowTransformers[name]
+
+	return transformer, ok
+}
+
+type transformKey int
+
+const (
+	transformMetaKey transformKey = iota
+	transformPageKey
+)
+
+// TransformMeta the meta of the target the rows are transformed for
+func TransformMeta(ctx context.Context) *SyncMeta {
+	meta, _ := ctx.Value(transformMetaKey).(*SyncMeta)
+
+	return meta
+}
+
+// TransformPage the source page of the rows
+func TransformPage(ctx context.Context) int64 {
+	page, _ := ctx.Value(transformPageKey).(int64)
+
+	return page
+}
+
+func transformContext(meta *SyncMeta, page int64) context.Context {
+	return context.WithValue(context.WithValue(context.Background(), transformMetaKey, meta), transformPageKey, page)
+}
+
+// taskTransformers the registered transformers of a task in order
+func (task SyncerTask) taskTransformers() ([]RowTransformer, error) {
+	transformers := make([]RowTransformer, len(task.Transformers))
+	for i, name := range task.Transformers {
+		transformer, ok := GetTransformer(name)
+		if !ok {
+			return nil, fmt.Errorf("[syncer] transformer not found: %s", name)
+		}
+		transformers[i] = transformer
+	}
+
+	return transformers, nil
+}
+
+// transformRows runs the rows through each transformer in order
+func transformRows(ctx context.Context, transformers []RowTransformer, rows []map[string]any) ([]map[string]any, error) {
+	for _, transformer := range transformers {
+		if pt, ok := transformer.(PageTransformer); ok {
+			var e error
+			if rows, e = pt.TransformPage(ctx, rows); e != nil {
+				return nil, e
+			}
+			continue
+		}
+
+		var transformed []map[string]any
+		for _, row := range rows {
+			out, e := transformer.Transform(ctx, row)
+			if e != nil {
+				return nil, e
+			}
+			transformed = append(transformed, out...)
+		}
+		rows = transformed
+	}
+
+	return rows, nil
+}
@@ -0,0 +1,61 @@
+package syncer_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/enorith/syncer"
+	"github.com/enorith/syncer/ds"
+)
+
+func TestTransformers(t *testing.T) {
+	dir := fileFixture(t, map[string]string{
+		"users.csv": "id,name,tags\n1,a,x|y\n2,,z\n3,c,w\n",
+	})
+
+	syncer.RegisterTransformer("drop_unnamed", syncer.TransformerFunc(func(ctx context.Context, row map[string]any) ([]map[string]any, error) {
+		if ds.ValueString(row["name"]) == "" {
+			return nil, nil
+		}
+		row["task"] = syncer.TransformMeta(ctx).TaskID
+		return []map[string]any{row}, nil
+	}))
+	syncer.RegisterTransformer("split_tags", syncer.TransformerFunc(func(ctx context.Context, row map[string]any) ([]map[string]any, error) {
+		var rows []map[string]any
+		for _, tag := range strings.Split(ds.ValueString(row["tag"]), "|") {
+			rows = append(rows, map[string]any{"uid": row["uid"], "tag": tag, "task": row["task"]})
+		}
+		return rows, nil
+	}))
+
+	sy := loadTasks(t, fmt.Sprintf(`[{
+		"id": "user_tags",
+		"source": "csv://%[1]s/users.csv",
+		"mapping": {"id": "uid", "name": "name", "tags": "tag"},
+		"transformers": ["drop_unnamed", "split_tags"],
+		"target": "jsonl://%[1]s/tags.jsonl",
+		"size": 10,
+		"workers": 1
+	}]`, dir))
+
+	if _, e := sy.DoSync("user_tags"); e != nil {
+		t.Fatal(e)
+	}
+
+	target, _ := ds.Connect("jsonl://" + dir + "/tags.jsonl")
+	res, _ := target.List(ds.ListOption{Orders: []ds.ListOrder{{Field: "tag", Order: "asc"}}})
+	tags := make([]any, len(res.Data))
+	for i, data := range res.Data {
+		tags[i] = data.(map[string]any)["tag"]
+	}
+	if fmt.Sprint(tags) != "[w x y]" || res.Data[0].(map[string]any)["task"] != "user_tags" {
+		t.Fatalf("unexpected rows: %v", res.Data)
+	}
+
+	missing := loadTasks(t, `[{"id": "missing", "source": "csv://`+dir+`/users.csv", "transformers": ["nope"], "target": "jsonl://`+dir+`/x.jsonl", "size": 10, "workers": 1}]`)
+	if _, e := missing.DoSync("missing"); e == nil {
+		t.Fatal("expected error for unknown transformer")
+	}
+}
@@ -1,6 +1,10 @@
 package syncer
 
-import "strings"
+import (
+	"strings"
+
+	"github.com/enorith/syncer/ds"
+)
 
 func EndWith(haystack string, suffix ...string) (bool, string) {
 
@@ -12,3 +16,13 @@ func EndWith(haystack string, suffix ...string) (bool, string) {
 
 	return false, ""
 }
+
+// rowKey joins the values of keys, values of different types with the same text share a key
+func rowKey(row map[string]any, keys []string) string {
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = ds.ValueString(row[key])
+	}
+
+	return strings.Join(parts, "\x00")
+}